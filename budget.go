@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budget limits spending in dollars, as priced by the config's price
+// table. Zero means no limit.
+type Budget struct {
+	Daily   float64 `yaml:"daily"`
+	Monthly float64 `yaml:"monthly"`
+}
+
+func (b Budget) isSet() bool {
+	return b.Daily > 0 || b.Monthly > 0
+}
+
+// BudgetError is returned when a call would exceed a budget.
+type BudgetError struct {
+	Prompt   string // empty for the global budget
+	Period   string // daily or monthly
+	Limit    float64
+	Spent    float64
+	Estimate float64
+}
+
+func (e *BudgetError) Error() string {
+	scope := "global"
+	if e.Prompt != "" {
+		scope = fmt.Sprintf("prompt %q", e.Prompt)
+	}
+	return fmt.Sprintf("%s %s budget of $%.2f would be exceeded ($%.4f spent, this call ~$%.4f); use --ignore-budget to run anyway",
+		scope, e.Period, e.Limit, e.Spent, e.Estimate)
+}
+
+// estimateCost prices req before sending it, counting the whole output
+// token limit as output when one is set.
+func estimateCost(prices map[string]Price, model string, req *Request) float64 {
+	p, ok := findPrice(prices, model)
+	if !ok {
+		return 0
+	}
+	cost := float64(estimateTokens(req)) * p.Input
+	if req.Params.MaxOutputTokens != nil {
+		cost += float64(*req.Params.MaxOutputTokens) * p.Output
+	}
+	return cost / 1e6
+}
+
+// CheckBudget reports whether sending req would exceed the global or the
+// prompt's budget, given the ledger entries of the current month.
+func CheckBudget(cfg *Config, prompt *Prompt, req *Request, entries []UsageEntry, now time.Time) error {
+	estimate := estimateCost(cfg.Prices, prompt.Model, req)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	check := func(b Budget, name string) error {
+		var daily, monthly float64
+		for _, e := range entries {
+			if name != "" && e.Prompt != name {
+				continue
+			}
+			cost, _ := e.Cost(cfg.Prices)
+			if !e.Time.Before(month) {
+				monthly += cost
+			}
+			if !e.Time.Before(day) {
+				daily += cost
+			}
+		}
+		if b.Daily > 0 && daily+estimate > b.Daily {
+			return &BudgetError{Prompt: name, Period: "daily", Limit: b.Daily, Spent: daily, Estimate: estimate}
+		}
+		if b.Monthly > 0 && monthly+estimate > b.Monthly {
+			return &BudgetError{Prompt: name, Period: "monthly", Limit: b.Monthly, Spent: monthly, Estimate: estimate}
+		}
+		return nil
+	}
+
+	if err := check(prompt.Budget, prompt.Name); err != nil {
+		return err
+	}
+	return check(cfg.Budget, "")
+}
+
+// checkBudget checks req against the budgets using the usage ledger.
+func checkBudget(cfg *Config, prompt *Prompt, req *Request) error {
+	if !cfg.Budget.isSet() && !prompt.Budget.isSet() {
+		return nil
+	}
+	path, err := ledgerPath()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	entries, err := ReadUsage(path, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		return fmt.Errorf("reading usage ledger: %w", err)
+	}
+	return CheckBudget(cfg, prompt, req, entries, now)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckBudget(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	prices := map[string]Price{"gemini-2.5-pro": {Input: 1, Output: 10}}
+	review := &Prompt{Name: "review", Model: "gemini-2.5-pro", Budget: Budget{Daily: 1}}
+	summary := &Prompt{Name: "summary", Model: "gemini-2.5-pro"}
+
+	// Today: $0.90 of review and $4 of summary. Earlier this month: $14.
+	entries := []UsageEntry{
+		{Time: now.Add(-time.Hour), Prompt: "review", Model: "gemini-2.5-pro", InputTokens: 400_000, OutputTokens: 50_000},
+		{Time: now.Add(-2 * time.Hour), Prompt: "summary", Model: "gemini-2.5-pro", OutputTokens: 400_000},
+		{Time: now.AddDate(0, 0, -5), Prompt: "summary", Model: "gemini-2.5-pro", OutputTokens: 1_400_000},
+	}
+
+	// The estimate counts the whole output limit: $0.10 per 10k tokens.
+	request := func(maxOutputTokens int32) *Request {
+		return &Request{Parts: []string{"short"}, Params: Params{MaxOutputTokens: &maxOutputTokens}}
+	}
+
+	tests := []struct {
+		name     string
+		budget   Budget
+		prompt   *Prompt
+		req      *Request
+		expected *BudgetError
+	}{
+		{"within budgets", Budget{Daily: 5, Monthly: 20}, review, &Request{Parts: []string{"short"}}, nil},
+		{"prompt daily", Budget{Daily: 5, Monthly: 20}, review, request(100_000), &BudgetError{Prompt: "review", Period: "daily", Limit: 1}},
+		{"global daily just below", Budget{Daily: 5, Monthly: 20}, summary, request(9_000), nil},
+		{"global daily", Budget{Daily: 5, Monthly: 20}, summary, request(20_000), &BudgetError{Period: "daily", Limit: 5}},
+		{"global monthly", Budget{Monthly: 20}, summary, request(150_000), &BudgetError{Period: "monthly", Limit: 20}},
+	}
+
+	for _, tt := range tests {
+		cfg := &Config{Prices: prices, Budget: tt.budget}
+		err := CheckBudget(cfg, tt.prompt, tt.req, entries, now)
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", tt.name, err)
+			}
+			continue
+		}
+		var budgetErr *BudgetError
+		if !errors.As(err, &budgetErr) {
+			t.Errorf("%s: expected BudgetError, got %v", tt.name, err)
+			continue
+		}
+		if budgetErr.Prompt != tt.expected.Prompt || budgetErr.Period != tt.expected.Period || budgetErr.Limit != tt.expected.Limit {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.expected, budgetErr)
+		}
+	}
+}
+
+func TestBudgetErrorMessage(t *testing.T) {
+	err := &BudgetError{Prompt: "review", Period: "daily", Limit: 1, Spent: 0.9, Estimate: 0.25}
+	expected := `prompt "review" daily budget of $1.00 would be exceeded ($0.9000 spent, this call ~$0.2500); use --ignore-budget to run anyway`
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestCheckBudgetUnpricedModel(t *testing.T) {
+	cfg := &Config{Budget: Budget{Daily: 0.01}}
+	prompt := &Prompt{Name: "summary", Model: "llama3"}
+
+	// Local models without a price cost nothing.
+	entries := []UsageEntry{{Time: time.Now(), Prompt: "summary", Model: "llama3", InputTokens: 1_000_000}}
+	if err := CheckBudget(cfg, prompt, &Request{Parts: []string{"hi"}}, entries, time.Now()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
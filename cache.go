@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCacheTTL     = 7 * 24 * time.Hour
+	defaultCacheSizeMB  = 100
+	cacheModeDefault    = "deterministic"
+	cacheModeAlways     = "always"
+	cacheModeOff        = "off"
+	cacheEntryExtension = ".json"
+)
+
+// CacheConfig controls the on-disk response cache.
+type CacheConfig struct {
+	Mode      string `yaml:"mode"`        // deterministic (default), always or off
+	TTL       string `yaml:"ttl"`         // e.g. 24h; default 168h
+	MaxSizeMB int    `yaml:"max_size_mb"` // default 100
+}
+
+func (c CacheConfig) validate() error {
+	switch c.Mode {
+	case "", cacheModeDefault, cacheModeAlways, cacheModeOff:
+	default:
+		return fmt.Errorf("unknown cache mode %q (want deterministic, always or off)", c.Mode)
+	}
+	if c.TTL != "" {
+		if d, err := time.ParseDuration(c.TTL); err != nil || d <= 0 {
+			return fmt.Errorf("invalid cache ttl %q", c.TTL)
+		}
+	}
+	if c.MaxSizeMB < 0 {
+		return fmt.Errorf("invalid cache max_size_mb %d", c.MaxSizeMB)
+	}
+	return nil
+}
+
+// Cache stores responses in files named after the hash of the request.
+// Reads refresh a file's modification time, which drives LRU eviction.
+type Cache struct {
+	dir      string
+	mode     string
+	ttl      time.Duration
+	maxBytes int64
+}
+
+type cacheEntry struct {
+	Created  time.Time `json:"created"`
+	Response *Response `json:"response"`
+}
+
+// OpenCache returns the cache in $XDG_CACHE_HOME/pipellm/responses.
+func OpenCache(cfg CacheConfig) (*Cache, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:      filepath.Join(dir, "pipellm", "responses"),
+		mode:     cfg.Mode,
+		ttl:      defaultCacheTTL,
+		maxBytes: defaultCacheSizeMB << 20,
+	}
+	if c.mode == "" {
+		c.mode = cacheModeDefault
+	}
+	if cfg.TTL != "" {
+		c.ttl, _ = time.ParseDuration(cfg.TTL)
+	}
+	if cfg.MaxSizeMB > 0 {
+		c.maxBytes = int64(cfg.MaxSizeMB) << 20
+	}
+	return c, nil
+}
+
+// Cacheable reports whether req may be served from the cache. By default
+// only deterministic (temperature 0) requests are.
+func (c *Cache) Cacheable(req *Request) bool {
+	switch c.mode {
+	case cacheModeAlways:
+		return true
+	case cacheModeOff:
+		return false
+	}
+	return req.Params.Temperature != nil && *req.Params.Temperature == 0
+}
+
+// CacheKey hashes everything that determines the response to req from
+// model on the backend that cfg, as returned by providerConfig, selects.
+func CacheKey(cfg *Config, model string, req *Request) string {
+	key := struct {
+		Provider, Endpoint, Project, Location string
+		Model                                 string
+		Request                               *Request
+	}{Provider: providerName(cfg), Endpoint: cfg.Endpoint, Model: model, Request: req}
+	// Fill in the defaults the clients use, so that spelling them out in
+	// the config keeps the cached answers.
+	switch key.Provider {
+	case "vertex":
+		key.Project, key.Location = cfg.Project, cfg.Location
+		if key.Project == "" {
+			key.Project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+		}
+		if key.Location == "" {
+			key.Location = defaultVertexLocation
+		}
+	case "ollama":
+		if key.Endpoint == "" {
+			key.Endpoint = defaultOllamaEndpoint
+		}
+	}
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+cacheEntryExtension)
+}
+
+// Get returns the cached response for key, if there is a fresh one.
+func (c *Cache) Get(key string) (*Response, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || entry.Response == nil || time.Since(entry.Created) > c.ttl {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	return entry.Response, true
+}
+
+// Put stores resp under key and evicts entries beyond the size limit.
+func (c *Cache) Put(key string, resp *Response) error {
+	data, err := json.Marshal(cacheEntry{Created: time.Now(), Response: resp})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+
+	// Write atomically so concurrent pipelines never read half an entry.
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.evict()
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) files() ([]cacheFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []cacheFile
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), cacheEntryExtension) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{filepath.Join(c.dir, e.Name()), info.Size(), info.ModTime()})
+	}
+	return files, nil
+}
+
+// evict removes expired entries, then the least recently used ones until
+// the cache fits its size limit.
+func (c *Cache) evict() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+		if total > c.maxBytes || time.Since(f.modTime) > c.ttl {
+			os.Remove(f.path)
+		}
+	}
+	return nil
+}
+
+type CacheStats struct {
+	Dir     string
+	Entries int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+func (c *Cache) Stats() (*CacheStats, error) {
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	stats := &CacheStats{Dir: c.dir, Entries: len(files)}
+	for _, f := range files {
+		stats.Bytes += f.size
+		if stats.Oldest.IsZero() || f.modTime.Before(stats.Oldest) {
+			stats.Oldest = f.modTime
+		}
+		if f.modTime.After(stats.Newest) {
+			stats.Newest = f.modTime
+		}
+	}
+	return stats, nil
+}
+
+// Clear removes all entries and returns how many there were.
+func (c *Cache) Clear() (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
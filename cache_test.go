@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, cfg CacheConfig) *Cache {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	cache, err := OpenCache(cfg)
+	if err != nil {
+		t.Fatalf("OpenCache failed: %v", err)
+	}
+	return cache
+}
+
+func TestCacheCacheable(t *testing.T) {
+	zero, warm := float32(0), float32(0.7)
+	deterministic := &Request{Params: Params{Temperature: &zero}}
+	creative := &Request{Params: Params{Temperature: &warm}}
+	unset := &Request{}
+
+	tests := []struct {
+		mode     string
+		req      *Request
+		expected bool
+	}{
+		{"", deterministic, true},
+		{"", creative, false},
+		{"", unset, false},
+		{"always", creative, true},
+		{"off", deterministic, false},
+	}
+
+	for _, tt := range tests {
+		cache := newTestCache(t, CacheConfig{Mode: tt.mode})
+		if got := cache.Cacheable(tt.req); got != tt.expected {
+			t.Errorf("mode %q, params %+v: expected %v, got %v", tt.mode, tt.req.Params, tt.expected, got)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	zero := float32(0)
+	base := CacheKey(&Config{}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}})
+
+	if base != CacheKey(&Config{}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}}) {
+		t.Error("Expected identical requests to have the same key")
+	}
+
+	others := []string{
+		CacheKey(&Config{Provider: "ollama"}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}}),
+		CacheKey(&Config{}, "gemini-2.5-pro", &Request{Parts: []string{"Summarize\n\ntext"}}),
+		CacheKey(&Config{}, "gemini-pro", &Request{Parts: []string{"Summarize\n\nother text"}}),
+		CacheKey(&Config{}, "gemini-pro", &Request{System: "Be brief.", Parts: []string{"Summarize\n\ntext"}}),
+		CacheKey(&Config{}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}, Params: Params{Temperature: &zero}}),
+		CacheKey(&Config{Provider: "ollama", Endpoint: "http://gpu-box:11434"}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}}),
+		CacheKey(&Config{Provider: "vertex", Project: "a"}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}}),
+		CacheKey(&Config{Provider: "vertex", Project: "b"}, "gemini-pro", &Request{Parts: []string{"Summarize\n\ntext"}}),
+	}
+	for i, key := range others {
+		if key == base {
+			t.Errorf("Expected request %d to have a different key", i)
+		}
+	}
+	for i, a := range others {
+		for _, b := range others[i+1:] {
+			if a == b {
+				t.Errorf("Expected request %d to have a unique key", i)
+			}
+		}
+	}
+
+	// The default endpoint spelled out is the same backend.
+	req := &Request{Parts: []string{"hi"}}
+	if CacheKey(&Config{Provider: "ollama"}, "llama3", req) != CacheKey(&Config{Provider: "ollama", Endpoint: defaultOllamaEndpoint}, "llama3", req) {
+		t.Error("Expected the default Ollama endpoint to keep the key")
+	}
+}
+
+func TestCacheGetPut(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{})
+	key := CacheKey(&Config{}, "gemini-pro", &Request{Parts: []string{"hi"}})
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("Expected miss on empty cache")
+	}
+
+	resp := &Response{Text: "hello", FinishReason: "STOP", Usage: Usage{InputTokens: 1, OutputTokens: 2, TotalTokens: 3}}
+	if err := cache.Put(key, resp); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("Expected hit after Put")
+	}
+	if got.Text != "hello" || got.FinishReason != "STOP" || got.Usage != resp.Usage {
+		t.Errorf("Unexpected cached response: %+v", got)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{TTL: "1h"})
+	key := CacheKey(&Config{}, "gemini-pro", &Request{Parts: []string{"hi"}})
+	if err := cache.Put(key, &Response{Text: "hello"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Entries expire by creation time, even if they were used recently.
+	cache.ttl = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.Get(key); ok {
+		t.Error("Expected expired entry to miss")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{})
+
+	keys := []string{"a", "b", "c"}
+	for i, key := range keys {
+		if err := cache.Put(key, &Response{Text: "response"}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		old := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(cache.path(key), old, old)
+	}
+
+	// Using "a" makes "b" the least recently used entry.
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("Expected hit for a")
+	}
+
+	info, err := os.Stat(cache.path("a"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	// Entries differ by a few bytes in their timestamps.
+	cache.maxBytes = 3*info.Size() + info.Size()/2
+	if err := cache.Put("d", &Response{Text: "response"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		_, err := os.Stat(cache.path(key))
+		if exists := err == nil; exists != expected {
+			t.Errorf("Entry %s: expected exists=%v, got %v", key, expected, exists)
+		}
+	}
+}
+
+func TestCacheStatsClear(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{})
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Put(key, &Response{Text: "response"}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	// Leftover temporary files are not entries.
+	os.WriteFile(filepath.Join(cache.dir, ".tmp-123"), []byte("partial"), 0o600)
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Entries != 2 || stats.Bytes == 0 || stats.Oldest.After(stats.Newest) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	n, err := cache.Clear()
+	if err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 removed entries, got %d", n)
+	}
+	if stats, _ := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("Expected empty cache after Clear, got %d entries", stats.Entries)
+	}
+}
+
+func TestCacheConfigValidate(t *testing.T) {
+	for _, cfg := range []CacheConfig{{Mode: "sometimes"}, {TTL: "forever"}, {TTL: "-1h"}, {MaxSizeMB: -1}} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected error for %+v, got nil", cfg)
+		}
+	}
+	if err := (CacheConfig{Mode: "always", TTL: "24h", MaxSizeMB: 10}).validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+}
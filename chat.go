@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const chatHelp = `Commands:
+  /model [name]     Show or switch the model
+  /prompt <name> [var=value...]
+                    Switch to another prompt, keeping the conversation
+  /reset            Forget the conversation
+  /save <file>      Save the conversation as JSON
+  /load <file>      Load a conversation saved with /save
+  /help             Show this help
+  /quit             Leave (or press Ctrl-D)
+
+End a line with \ to continue on the next one, or wrap several lines
+in """ to send them as one message.
+`
+
+// chat is an interactive conversation with one prompt. The prompt text is
+// sent along with the first message; the system instruction and params
+// apply to every turn.
+type chat struct {
+	cfg      *Config
+	prompt   *Prompt
+	provider Provider
+	history  []Message
+	pending  string
+	render   bool
+	session  string  // file to keep the conversation in, if any
+	vars     varFlag // --var values, also for prompts switched to
+
+	confirmTools bool
+	tools        *toolRunner // started on the first message
+	toolsReady   bool
+
+	in          *bufio.Scanner
+	out         io.Writer
+	newProvider func(cfg *Config, modelName string) (Provider, error)
+	recordUsage func(prompt, provider, model string, resp *Response)
+	checkBudget func(cfg *Config, prompt *Prompt, req *Request) error
+}
+
+func newChat(cfg *Config, prompt *Prompt, in io.Reader, out io.Writer) *chat {
+	return &chat{
+		cfg:         cfg,
+		prompt:      prompt,
+		pending:     prompt.Prompt,
+		in:          bufio.NewScanner(in),
+		out:         out,
+		newProvider: connectProvider,
+		recordUsage: recordUsage,
+		checkBudget: checkBudget,
+	}
+}
+
+// runChat starts an interactive chat on the terminal, continuing the
+// conversation in session if it is not nil.
+func runChat(cfg *Config, prompt *Prompt, render bool, session *Transcript, sessionFile string, opts *runOptions) error {
+	c := newChat(cfg, prompt, os.Stdin, os.Stdout)
+	c.render = render
+	c.confirmTools = opts.confirmTools
+	c.vars = opts.vars
+	if opts.ignoreBudget {
+		c.checkBudget = nil
+	}
+	if session != nil {
+		c.session = sessionFile
+		c.setHistory(session.History)
+	}
+	return c.run(context.Background())
+}
+
+func (c *chat) run(ctx context.Context) error {
+	defer c.closeTools()
+	if err := c.setModel(c.prompt.Model); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Chatting with %s (%s). Type /help for commands.\n", c.prompt.Name, c.prompt.Model)
+
+	for {
+		fmt.Fprint(c.out, "> ")
+		text, ok := c.readMessage()
+		if !ok {
+			fmt.Fprintln(c.out)
+			return c.in.Err()
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "/") {
+			quit, err := c.command(text)
+			if err != nil {
+				fmt.Fprintf(c.out, "Error: %v\n", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		if err := c.send(ctx, text); err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// readMessage reads one message, joining lines ending in a backslash
+// and lines between """ markers.
+func (c *chat) readMessage() (string, bool) {
+	if !c.in.Scan() {
+		return "", false
+	}
+	line := c.in.Text()
+
+	if strings.TrimSpace(line) == `"""` {
+		var lines []string
+		for c.in.Scan() {
+			if strings.TrimSpace(c.in.Text()) == `"""` {
+				break
+			}
+			lines = append(lines, c.in.Text())
+		}
+		return strings.Join(lines, "\n"), true
+	}
+
+	var lines []string
+	for strings.HasSuffix(line, `\`) {
+		lines = append(lines, strings.TrimSuffix(line, `\`))
+		if !c.in.Scan() {
+			line = ""
+			break
+		}
+		line = c.in.Text()
+	}
+	return strings.Join(append(lines, line), "\n"), true
+}
+
+func (c *chat) send(ctx context.Context, text string) error {
+	if c.pending != "" {
+		text = c.pending + "\n\n" + text
+	}
+	req := &Request{
+		System:  c.prompt.System,
+		History: c.history,
+		Parts:   []string{text},
+		Params:  c.prompt.Params,
+		Safety:  c.prompt.Safety,
+	}
+	tools, err := c.toolRunner(ctx)
+	if err != nil {
+		return err
+	}
+	if tools != nil {
+		req.Tools = tools.specs
+	}
+
+	resp, provider, model, err := c.generate(ctx, req)
+	if err != nil {
+		return err
+	}
+	if tools != nil {
+		// Tool rounds stay with the model that answered.
+		send := func(r *Request) (*Response, error) { return c.call(ctx, provider, model, r) }
+		if req, resp, err = tools.runTools(ctx, req, resp, send); err != nil {
+			return err
+		}
+	}
+	c.history = append(append([]Message(nil), req.History...), req.turn(), resp.turn())
+	c.pending = ""
+	if err := c.persist(); err != nil {
+		return err
+	}
+
+	reply := resp.Text
+	if c.render {
+		reply = RenderMarkdown(reply, terminalWidth())
+	}
+	fmt.Fprintln(c.out, strings.TrimRight(reply, "\n"))
+	if resp.FinishReason == "MAX_TOKENS" {
+		fmt.Fprintln(c.out, "(cut off at the output token limit)")
+	}
+	return nil
+}
+
+// generate sends req to the prompt's model, falling back to the next
+// ones on the errors the command line falls back on. It returns the
+// provider and model that answered, for the tool calls that follow.
+func (c *chat) generate(ctx context.Context, req *Request) (*Response, Provider, string, error) {
+	models := c.prompt.Models()
+	for i, m := range models {
+		provider := c.provider
+		if i > 0 {
+			p, err := c.newProvider(c.cfg, m)
+			if err != nil {
+				return nil, nil, "", fmt.Errorf("creating client: %w", err)
+			}
+			provider = p
+		}
+		resp, err := c.call(ctx, provider, m, req)
+		if err != nil {
+			if !shouldFallback(err) || i == len(models)-1 || ctx.Err() != nil {
+				return nil, nil, "", err
+			}
+			fmt.Fprintf(c.out, "%s failed, falling back: %v\n", m, err)
+			continue
+		}
+		return resp, provider, m, nil
+	}
+	return nil, nil, "", fmt.Errorf("no model configured")
+}
+
+// call sends one request to model the way the command line does: within
+// the budget and the configured timeout, recording the usage.
+func (c *chat) call(ctx context.Context, provider Provider, model string, req *Request) (*Response, error) {
+	backend, name := splitModel(c.cfg, model)
+	if c.checkBudget != nil {
+		priced := *c.prompt
+		priced.Model = name
+		if err := c.checkBudget(c.cfg, &priced, req); err != nil {
+			return nil, err
+		}
+	}
+	timeout, err := c.cfg.requestTimeout()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := generateWithTimeout(ctx, provider, req, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.recordUsage != nil {
+		c.recordUsage(c.prompt.Name, backend, name, resp)
+	}
+	return resp, nil
+}
+
+// setHistory replaces the conversation. The prompt text is only sent
+// again if it is empty.
+func (c *chat) setHistory(history []Message) {
+	c.history = history
+	if len(history) > 0 {
+		c.pending = ""
+	} else {
+		c.pending = c.prompt.Prompt
+	}
+}
+
+func (c *chat) transcript() *Transcript {
+	return &Transcript{Prompt: c.prompt.Name, Model: c.prompt.Model, History: c.history}
+}
+
+// persist saves the conversation to the session file, if there is one.
+func (c *chat) persist() error {
+	if c.session == "" {
+		return nil
+	}
+	if err := c.transcript().Save(c.session); err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+	return nil
+}
+
+// toolRunner returns the runner for the prompt's tools, starting it on
+// first use.
+func (c *chat) toolRunner(ctx context.Context) (*toolRunner, error) {
+	if c.toolsReady {
+		return c.tools, nil
+	}
+	tools, err := newToolRunner(ctx, c.cfg, c.prompt, c.confirmTools)
+	if err != nil {
+		return nil, err
+	}
+	if tools != nil {
+		tools.log = c.out
+	}
+	c.tools, c.toolsReady = tools, true
+	return tools, nil
+}
+
+func (c *chat) closeTools() {
+	if c.tools != nil {
+		c.tools.Close()
+	}
+	c.tools, c.toolsReady = nil, false
+}
+
+func (c *chat) setModel(name string) error {
+	provider, err := c.newProvider(c.cfg, name)
+	if err != nil {
+		return fmt.Errorf("creating client: %w", err)
+	}
+	c.provider = provider
+	c.prompt.Model = name
+	backend, _ := splitModel(c.cfg, name)
+	warnUnsupportedSafety(backend, c.prompt.Safety)
+	return nil
+}
+
+// command runs a slash command and reports whether to leave the chat.
+func (c *chat) command(line string) (bool, error) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "/quit", "/exit":
+		return true, nil
+	case "/help":
+		fmt.Fprint(c.out, chatHelp)
+	case "/model":
+		if arg == "" {
+			fmt.Fprintln(c.out, c.prompt.Model)
+			return false, nil
+		}
+		if err := c.setModel(arg); err != nil {
+			return false, err
+		}
+		c.prompt.Fallbacks = nil
+		fmt.Fprintf(c.out, "Switched to %s.\n", arg)
+	case "/prompt":
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			return false, fmt.Errorf("usage: /prompt <name> [var=value...]")
+		}
+		p := c.cfg.ResolvePrompt(fields[0])
+		if p == nil {
+			return false, fmt.Errorf("no prompt found for name: %s", fields[0])
+		}
+		for _, field := range fields[1:] {
+			if err := c.vars.Set(field); err != nil {
+				return false, fmt.Errorf("invalid variable %q: %w", field, err)
+			}
+		}
+		// Values for the other prompts are kept, but not passed on.
+		values := make(map[string]string)
+		for _, v := range p.Variables {
+			if value, ok := c.vars[v.Name]; ok {
+				values[v.Name] = value
+			}
+		}
+		p, err := p.Expand(values)
+		if err != nil {
+			return false, err
+		}
+		p.Model, p.Fallbacks = c.prompt.Model, c.prompt.Fallbacks
+		c.closeTools()
+		c.prompt, c.pending = p, p.Prompt
+		fmt.Fprintf(c.out, "Switched to prompt %s.\n", p.Name)
+	case "/reset":
+		c.setHistory(nil)
+		if err := c.persist(); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(c.out, "Conversation cleared.")
+	case "/save":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /save <file>")
+		}
+		if err := c.transcript().Save(arg); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(c.out, "Saved %d messages to %s.\n", len(c.history), arg)
+	case "/load":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /load <file>")
+		}
+		t, err := LoadTranscript(arg)
+		if err != nil {
+			return false, err
+		}
+		if t.Model != "" && t.Model != c.prompt.Model {
+			if err := c.setModel(t.Model); err != nil {
+				return false, err
+			}
+			c.prompt.Fallbacks = nil
+		}
+		c.setHistory(t.History)
+		if err := c.persist(); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(c.out, "Loaded %d messages from %s.\n", len(c.history), arg)
+	default:
+		return false, fmt.Errorf("unknown command %s (try /help)", cmd)
+	}
+	return false, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider answers every request with a fixed reply and records it.
+type fakeProvider struct {
+	model    string
+	reply    string
+	requests []*Request
+}
+
+func (f *fakeProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	f.requests = append(f.requests, req)
+	return &Response{Text: f.reply + " (" + f.model + ")"}, nil
+}
+
+func newTestChat(input string, cfg *Config) (*chat, *bytes.Buffer, map[string]*fakeProvider) {
+	var out bytes.Buffer
+	providers := make(map[string]*fakeProvider)
+	c := newChat(cfg, cfg.ResolvePrompt(cfg.Prompts[0].Name), strings.NewReader(input), &out)
+	c.newProvider = func(cfg *Config, modelName string) (Provider, error) {
+		p := &fakeProvider{model: modelName, reply: "ok"}
+		providers[modelName] = p
+		return p, nil
+	}
+	c.recordUsage, c.checkBudget = nil, nil
+	return c, &out, providers
+}
+
+func TestChatConversation(t *testing.T) {
+	cfg := &Config{
+		Model: "gemini-pro",
+		Prompts: []Prompt{
+			{Name: "socrates", System: "You are Socrates.", Prompt: "Question everything."},
+		},
+	}
+	input := "What is virtue?\n" +
+		"Is it\\\nteachable?\n" +
+		"\"\"\"\nline one\n\nline three\n\"\"\"\n"
+
+	c, out, providers := newTestChat(input, cfg)
+	if err := c.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	reqs := providers["gemini-pro"].requests
+	if len(reqs) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(reqs))
+	}
+
+	// The prompt text only goes with the first message.
+	expectedParts := []string{"Question everything.\n\nWhat is virtue?", "Is it\nteachable?", "line one\n\nline three"}
+	for i, req := range reqs {
+		if req.System != "You are Socrates." {
+			t.Errorf("Request %d: expected system instruction, got %q", i, req.System)
+		}
+		if len(req.Parts) != 1 || req.Parts[0] != expectedParts[i] {
+			t.Errorf("Request %d: expected parts %q, got %q", i, expectedParts[i], req.Parts)
+		}
+		if len(req.History) != 2*i {
+			t.Errorf("Request %d: expected %d history messages, got %d", i, 2*i, len(req.History))
+		}
+	}
+
+	expectedHistory := []Message{
+		{Role: "user", Parts: []string{"Question everything.\n\nWhat is virtue?"}},
+		{Role: "model", Parts: []string{"ok (gemini-pro)"}},
+		{Role: "user", Parts: []string{"Is it\nteachable?"}},
+		{Role: "model", Parts: []string{"ok (gemini-pro)"}},
+	}
+	if !reflect.DeepEqual(reqs[2].History, expectedHistory) {
+		t.Errorf("Unexpected history: %+v", reqs[2].History)
+	}
+
+	if !strings.Contains(out.String(), "> ok (gemini-pro)\n") {
+		t.Errorf("Expected reply in output, got:\n%s", out.String())
+	}
+}
+
+func TestChatCommands(t *testing.T) {
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "chat.json")
+	cfg := &Config{
+		Model: "gemini-pro",
+		Prompts: []Prompt{
+			{Name: "socrates", Prompt: "Question everything."},
+			{Name: "kharms", System: "Write like Kharms.", Prompt: "Retell this."},
+		},
+	}
+	input := "hello\n" +
+		"/save " + saved + "\n" +
+		"/reset\n" +
+		"/model gemini-2.5-pro\n" +
+		"/prompt kharms\n" +
+		"a story\n" +
+		"/load " + saved + "\n" +
+		"again\n" +
+		"/bogus\n" +
+		"/quit\n" +
+		"never sent\n"
+
+	c, out, providers := newTestChat(input, cfg)
+	if err := c.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	data, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatalf("Failed to read saved chat: %v", err)
+	}
+	var transcript Transcript
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		t.Fatalf("Failed to parse saved chat: %v", err)
+	}
+	if transcript.Prompt != "socrates" || transcript.Model != "gemini-pro" || len(transcript.History) != 2 {
+		t.Errorf("Unexpected transcript: %+v", transcript)
+	}
+
+	// After /reset, /model and /prompt the new prompt starts afresh.
+	pro := providers["gemini-2.5-pro"].requests
+	if len(pro) != 1 {
+		t.Fatalf("Expected 1 request to gemini-2.5-pro, got %d", len(pro))
+	}
+	if pro[0].System != "Write like Kharms." || pro[0].Parts[0] != "Retell this.\n\na story" || len(pro[0].History) != 0 {
+		t.Errorf("Unexpected request after /prompt: %+v", pro[0])
+	}
+
+	// /load restores both the history and the saved model; /quit stops
+	// before the last line is sent.
+	reqs := providers["gemini-pro"].requests
+	if len(reqs) != 1 || len(reqs[0].History) != 2 || reqs[0].Parts[0] != "again" {
+		t.Errorf("Unexpected requests after /load: %+v", reqs)
+	}
+
+	for _, want := range []string{"Conversation cleared.", "Switched to gemini-2.5-pro.", "Loaded 2 messages", "Error: unknown command /bogus"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestChatPromptVariables(t *testing.T) {
+	cfg := &Config{
+		Model: "gemini-pro",
+		Prompts: []Prompt{
+			{Name: "socrates", Prompt: "Question everything."},
+			{Name: "translate", Prompt: "Translate into {{language}} ({{tone}}):", Variables: []Variable{{Name: "language"}, {Name: "tone"}}},
+			{Name: "review", Prompt: "Review this {{language}} code:", Variables: []Variable{{Name: "language"}}},
+		},
+	}
+	input := "/prompt translate\n" +
+		"/prompt translate tone=formal\n" +
+		"bonjour\n" +
+		"/prompt socrates\n" +
+		"/prompt review\n" +
+		"x := 1\n"
+	c, out, providers := newTestChat(input, cfg)
+	c.vars = varFlag{"language": "German"}
+	if err := c.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "Error: prompt translate needs variable tone") {
+		t.Errorf("Expected the missing variable to be reported, got:\n%s", out.String())
+	}
+	reqs := providers["gemini-pro"].requests
+	if len(reqs) != 2 || reqs[0].Parts[0] != "Translate into German (formal):\n\nbonjour" || !strings.HasPrefix(reqs[1].Parts[0], "Review this German code:") {
+		t.Errorf("Expected the variables to carry over, got %+v", reqs)
+	}
+}
+
+// overloadedProvider fails every request with a 503.
+type overloadedProvider struct{}
+
+func (overloadedProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return nil, &APIError{Provider: "gemini", StatusCode: 503, Message: "overloaded"}
+}
+
+func TestChatFallback(t *testing.T) {
+	cfg := &Config{
+		Model:     "gemini-pro",
+		Fallbacks: []string{"local:llama3"},
+		Prompts:   []Prompt{{Name: "socrates", Prompt: "Question everything."}},
+	}
+	c, out, providers := newTestChat("hello\n", cfg)
+	newProvider := c.newProvider
+	c.newProvider = func(cfg *Config, modelName string) (Provider, error) {
+		if modelName == "gemini-pro" {
+			return overloadedProvider{}, nil
+		}
+		return newProvider(cfg, modelName)
+	}
+	var priced, recorded []string
+	c.checkBudget = func(cfg *Config, p *Prompt, req *Request) error {
+		priced = append(priced, p.Model)
+		return nil
+	}
+	c.recordUsage = func(prompt, provider, model string, resp *Response) {
+		recorded = append(recorded, provider+"/"+model)
+	}
+	if err := c.run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if reqs := providers["local:llama3"].requests; len(reqs) != 1 {
+		t.Errorf("Expected the fallback to answer, got %d requests", len(reqs))
+	}
+	if !strings.Contains(out.String(), "gemini-pro failed, falling back") || !strings.Contains(out.String(), "ok (local:llama3)") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+	if strings.Join(priced, ",") != "gemini-pro,llama3" || strings.Join(recorded, ",") != "ollama/llama3" {
+		t.Errorf("Expected split model names, got priced %q, recorded %q", priced, recorded)
+	}
+}
+
+// toolCallingProvider asks for the echo tool, then hangs until the
+// request is cancelled.
+type toolCallingProvider struct{}
+
+func (toolCallingProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	if len(req.Results) == 0 {
+		return &Response{ToolCalls: []ToolCall{{Name: "echo", Args: map[string]any{"a": 1}}}}, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestChatToolRoundsChecked(t *testing.T) {
+	cfg := &Config{
+		Model:        "gemini-pro",
+		Timeout:      "50ms",
+		AllowedTools: []string{"echo"},
+		Sandbox:      Sandbox{AuditLog: filepath.Join(t.TempDir(), "tools.jsonl")},
+		Prompts: []Prompt{{
+			Name:  "agent",
+			Tools: []Tool{{ToolSpec: ToolSpec{Name: "echo"}, Command: "cat"}},
+		}},
+	}
+	c, out, _ := newTestChat("go\n", cfg)
+	c.newProvider = func(cfg *Config, modelName string) (Provider, error) {
+		return toolCallingProvider{}, nil
+	}
+	var priced int
+	c.checkBudget = func(cfg *Config, p *Prompt, req *Request) error {
+		priced++
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the tool round to time out")
+	}
+
+	if priced != 2 {
+		t.Errorf("Expected both rounds to be checked against the budget, got %d", priced)
+	}
+	if !strings.Contains(out.String(), "deadline exceeded") {
+		t.Errorf("Expected the timeout to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestNewOllamaRequestHistory(t *testing.T) {
+	req := &Request{
+		System:  "Be brief.",
+		History: []Message{{Role: "user", Parts: []string{"Hi"}}, {Role: "model", Parts: []string{"Hello"}}},
+		Parts:   []string{"Bye"},
+	}
+
+	got := newOllamaRequest("llama3", req).Messages
+	expected := []ollamaMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello"},
+		{Role: "user", Content: "Bye"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected messages %+v, got %+v", expected, got)
+	}
+}
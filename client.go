@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"unicode"
 
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 type Client struct {
 	model *genai.GenerativeModel
+	name  string
 }
 
 func NewClient(apiKey, modelName string) (*Client, error) {
@@ -22,31 +28,269 @@ func NewClient(apiKey, modelName string) (*Client, error) {
 	model := client.GenerativeModel(modelName)
 	return &Client{
 		model: model,
+		name:  modelName,
 	}, nil
 }
 
 func (c *Client) SendPrompt(prompt, input string) (string, error) {
-	fullPrompt := prompt
-	if input != "" {
-		fullPrompt = prompt + "\n\n" + input
+	resp, err := c.Generate(context.Background(), NewRequest(&Prompt{Prompt: prompt}, input))
+	if err != nil {
+		return "", err
 	}
+	return resp.Text, nil
+}
 
-	ctx := context.Background()
-	resp, err := c.model.GenerateContent(ctx, genai.Text(fullPrompt))
+func (c *Client) Generate(ctx context.Context, req *Request) (*Response, error) {
+	model, parts := c.prepare(req)
+	var (
+		resp *genai.GenerateContentResponse
+		err  error
+	)
+	if len(req.History) == 0 {
+		resp, err = model.GenerateContent(ctx, parts...)
+	} else {
+		resp, err = startChat(model, req).SendMessage(ctx, parts...)
+	}
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return nil, blockedError(blocked)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return c.response(req, resp)
+}
+
+// GenerateStream is Generate, passing on the text as it arrives.
+func (c *Client) GenerateStream(ctx context.Context, req *Request, onText func(string)) (*Response, error) {
+	model, parts := c.prepare(req)
+	var iter *genai.GenerateContentResponseIterator
+	if len(req.History) == 0 {
+		iter = model.GenerateContentStream(ctx, parts...)
+	} else {
+		iter = startChat(model, req).SendMessageStream(ctx, parts...)
+	}
+	var usage *genai.UsageMetadata
+	for {
+		chunk, err := iter.Next()
+		if err == iterator.Done || streamClosed(err) {
+			break
+		}
+		var blocked *genai.BlockedError
+		if errors.As(err, &blocked) {
+			return nil, blockedError(blocked)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Each chunk counts the tokens so far; the merged response
+		// keeps the first count.
+		if chunk.UsageMetadata != nil {
+			usage = chunk.UsageMetadata
+		}
+		if len(chunk.Candidates) > 0 && chunk.Candidates[0].Content != nil {
+			for _, part := range chunk.Candidates[0].Content.Parts {
+				if text, ok := part.(genai.Text); ok && text != "" {
+					onText(string(text))
+				}
+			}
+		}
+	}
+	resp := iter.MergedResponse()
+	if resp == nil {
+		return nil, &ResponseError{Kind: ErrEmpty, Provider: "Gemini"}
 	}
+	resp.UsageMetadata = usage
+	return c.response(req, resp)
+}
+
+// streamClosed reports whether err is the SDK failing to read the ']'
+// that closes a streamed response, as it does when encoding/json is
+// built on its v2 implementation: the stream is complete.
+func streamClosed(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) && strings.HasPrefix(syntaxErr.Error(), "invalid character ']'")
+}
 
-	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response from Gemini")
+// prepare returns a copy of the model with the settings of req, so that
+// they do not leak between calls, and the parts of its turn.
+func (c *Client) prepare(req *Request) (*genai.GenerativeModel, []genai.Part) {
+	model := *c.model
+	model.Temperature = req.Params.Temperature
+	model.TopP = req.Params.TopP
+	model.TopK = req.Params.TopK
+	model.MaxOutputTokens = req.Params.MaxOutputTokens
+	model.SafetySettings = req.Safety.genaiSettings()
+	model.Tools = genaiTools(req.Tools)
+	if req.System != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
 	}
+	return &model, genaiParts(req.turn())
+}
 
+// startChat returns a session with the history of req, as genai only
+// exposes multi-turn requests through a chat session.
+func startChat(model *genai.GenerativeModel, req *Request) *genai.ChatSession {
+	cs := model.StartChat()
+	for _, m := range req.History {
+		cs.History = append(cs.History, &genai.Content{Role: m.Role, Parts: genaiParts(m)})
+	}
+	return cs
+}
+
+// response converts the genai response to req.
+func (c *Client) response(req *Request, resp *genai.GenerateContentResponse) (*Response, error) {
+	if len(resp.Candidates) == 0 {
+		return nil, &ResponseError{Kind: ErrEmpty, Provider: "Gemini"}
+	}
+
+	cand := resp.Candidates[0]
 	var result string
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if txt, ok := part.(genai.Text); ok {
-			result += string(txt)
+	var (
+		calls []ToolCall
+		other []string
+	)
+	if cand.Content != nil {
+		for _, part := range cand.Content.Parts {
+			switch part := part.(type) {
+			case genai.Text:
+				result += string(part)
+			case genai.FunctionCall:
+				if len(req.Tools) == 0 {
+					// Not ours to answer, as no tools were declared.
+					other = append(other, "FunctionCall")
+					continue
+				}
+				calls = append(calls, ToolCall{Name: part.Name, Args: part.Args})
+			default:
+				other = append(other, strings.TrimPrefix(fmt.Sprintf("%T", part), "genai."))
+			}
 		}
 	}
 
-	return result, nil
+	// genai v0.20.1 reports neither the model version nor a response
+	// id, so the envelope names the requested model and has no id.
+	out := &Response{
+		Text:          result,
+		Model:         c.name,
+		FinishReason:  enumName(cand.FinishReason.String(), "FinishReason"),
+		SafetyRatings: safetyRatings(cand.SafetyRatings),
+		ToolCalls:     calls,
+		OtherParts:    other,
+	}
+	if result == "" && len(calls) == 0 {
+		err := checkFinish("Gemini", out.FinishReason, out.SafetyRatings)
+		err.Parts = other
+		return nil, err
+	}
+	if u := resp.UsageMetadata; u != nil {
+		out.Usage = Usage{
+			InputTokens:  int(u.PromptTokenCount),
+			OutputTokens: int(u.CandidatesTokenCount),
+			CachedTokens: int(u.CachedContentTokenCount),
+			TotalTokens:  int(u.TotalTokenCount),
+		}
+	}
+
+	return out, nil
+}
+
+func genaiParts(m Message) []genai.Part {
+	var parts []genai.Part
+	for _, p := range m.Parts {
+		parts = append(parts, genai.Text(p))
+	}
+	for _, c := range m.Calls {
+		parts = append(parts, genai.FunctionCall{Name: c.Name, Args: c.Args})
+	}
+	for _, r := range m.Results {
+		parts = append(parts, genai.FunctionResponse{Name: r.Name, Response: r.response()})
+	}
+	return parts
+}
+
+func genaiTools(specs []ToolSpec) []*genai.Tool {
+	if len(specs) == 0 {
+		return nil
+	}
+	tool := &genai.Tool{}
+	for _, s := range specs {
+		tool.FunctionDeclarations = append(tool.FunctionDeclarations, &genai.FunctionDeclaration{
+			Name:        s.Name,
+			Description: s.Description,
+			Parameters:  s.Parameters.genai(),
+		})
+	}
+	return []*genai.Tool{tool}
+}
+
+var genaiTypes = map[string]genai.Type{
+	"string":  genai.TypeString,
+	"number":  genai.TypeNumber,
+	"integer": genai.TypeInteger,
+	"boolean": genai.TypeBoolean,
+	"array":   genai.TypeArray,
+	"object":  genai.TypeObject,
+}
+
+func (s *Schema) genai() *genai.Schema {
+	if s == nil {
+		return nil
+	}
+	out := &genai.Schema{
+		Type:        genaiTypes[strings.ToLower(s.Type)],
+		Format:      s.Format,
+		Description: s.Description,
+		Nullable:    s.Nullable,
+		Enum:        s.Enum,
+		Items:       s.Items.genai(),
+		Required:    s.Required,
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, p := range s.Properties {
+			out.Properties[name] = p.genai()
+		}
+	}
+	return out
+}
+
+// blockedError converts the genai error for a blocked prompt or response.
+func blockedError(e *genai.BlockedError) *ResponseError {
+	out := &ResponseError{Kind: ErrBlocked, Provider: "Gemini"}
+	if e.Candidate != nil {
+		out.FinishReason = enumName(e.Candidate.FinishReason.String(), "FinishReason")
+		out.SafetyRatings = safetyRatings(e.Candidate.SafetyRatings)
+	}
+	if e.PromptFeedback != nil && e.PromptFeedback.BlockReason != genai.BlockReasonUnspecified {
+		out.BlockReason = enumName(e.PromptFeedback.BlockReason.String(), "BlockReason")
+		out.SafetyRatings = safetyRatings(e.PromptFeedback.SafetyRatings)
+	}
+	return out
+}
+
+func safetyRatings(ratings []*genai.SafetyRating) []SafetyRating {
+	var out []SafetyRating
+	for _, r := range ratings {
+		out = append(out, SafetyRating{
+			Category:    "HARM_CATEGORY_" + enumName(r.Category.String(), "HarmCategory"),
+			Probability: enumName(r.Probability.String(), "HarmProbability"),
+			Blocked:     r.Blocked,
+		})
+	}
+	return out
+}
+
+// enumName converts a genai enum name such as "FinishReasonMaxTokens"
+// to the REST form "MAX_TOKENS".
+func enumName(name, prefix string) string {
+	name = strings.TrimPrefix(name, prefix)
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
 }
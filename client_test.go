@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -204,3 +205,101 @@ func TestClientSendPromptInvalidJSON(t *testing.T) {
 		t.Fatal("Expected error when response is invalid JSON, got nil")
 	}
 }
+
+func TestClientGenerateMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockResponse := `{
+			"candidates": [{
+				"content": {"parts": [{"text": "Cut off"}], "role": "model"},
+				"finishReason": "MAX_TOKENS",
+				"safetyRatings": [
+					{"category": "HARM_CATEGORY_HATE_SPEECH", "probability": "NEGLIGIBLE"},
+					{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "MEDIUM", "blocked": true}
+				]
+			}],
+			"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 34, "cachedContentTokenCount": 5, "totalTokenCount": 46}
+		}`
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey("test-api-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create test genai client: %v", err)
+	}
+	defer client.Close()
+
+	pipellmClient := &Client{model: client.GenerativeModel("gemini-pro"), name: "gemini-pro"}
+
+	resp, err := pipellmClient.Generate(ctx, NewRequest(&Prompt{Prompt: "Test prompt"}, ""))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if resp.Model != "gemini-pro" {
+		t.Errorf("Expected model gemini-pro, got %q", resp.Model)
+	}
+
+	if resp.FinishReason != "MAX_TOKENS" {
+		t.Errorf("Expected finish reason MAX_TOKENS, got %q", resp.FinishReason)
+	}
+
+	expectedRatings := []SafetyRating{
+		{Category: "HARM_CATEGORY_HATE_SPEECH", Probability: "NEGLIGIBLE"},
+		{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "MEDIUM", Blocked: true},
+	}
+	if !reflect.DeepEqual(resp.SafetyRatings, expectedRatings) {
+		t.Errorf("Expected safety ratings %+v, got %+v", expectedRatings, resp.SafetyRatings)
+	}
+
+	expectedUsage := Usage{InputTokens: 12, OutputTokens: 34, CachedTokens: 5, TotalTokens: 46}
+	if resp.Usage != expectedUsage {
+		t.Errorf("Expected usage %+v, got %+v", expectedUsage, resp.Usage)
+	}
+}
+
+func TestClientGenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "gemini-pro:streamGenerateContent") {
+			t.Errorf("Expected path to contain 'gemini-pro:streamGenerateContent', got %s", r.URL.Path)
+		}
+		// The SDK reads the array a chunk at a time, so it is compact.
+		mockResponse := `[{"candidates": [{"content": {"parts": [{"text": "Streamed "}], "role": "model"}}],` +
+			` "usageMetadata": {"promptTokenCount": 12, "totalTokenCount": 12}},` +
+			`{"candidates": [{"content": {"parts": [{"text": "response"}], "role": "model"}, "finishReason": "STOP"}],` +
+			` "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 3, "totalTokenCount": 15}}]`
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey("test-api-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create test genai client: %v", err)
+	}
+	defer client.Close()
+
+	pipellmClient := &Client{model: client.GenerativeModel("gemini-pro"), name: "gemini-pro"}
+
+	var texts []string
+	resp, err := pipellmClient.GenerateStream(ctx, NewRequest(&Prompt{Prompt: "Test prompt"}, ""), func(text string) {
+		texts = append(texts, text)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(texts, []string{"Streamed ", "response"}) {
+		t.Errorf("Expected the text in two pieces, got %q", texts)
+	}
+	if resp.Text != "Streamed response" || resp.FinishReason != "STOP" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	expectedUsage := Usage{InputTokens: 12, OutputTokens: 3, TotalTokens: 15}
+	if resp.Usage != expectedUsage {
+		t.Errorf("Expected usage %+v, got %+v", expectedUsage, resp.Usage)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func cmdList(args []string, profile string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, p := range cfg.Prompts {
+		fmt.Fprintf(w, "%s\t%s\n", strings.TrimSpace(p.Name), p.Description)
+	}
+	return w.Flush()
+}
+
+func cmdShow(args []string, profile string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: pipellm show <name>")
+	}
+
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+
+	prompt := cfg.ResolvePrompt(rest[0])
+	if prompt == nil {
+		return fmt.Errorf("no prompt found for name: %s", rest[0])
+	}
+
+	showPrompt(os.Stdout, cfg, prompt)
+	return nil
+}
+
+func showPrompt(out io.Writer, cfg *Config, p *Prompt) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", p.Name)
+	if p.Description != "" {
+		fmt.Fprintf(w, "description:\t%s\n", p.Description)
+	}
+	provider, _ := splitModel(cfg, p.Model)
+	fmt.Fprintf(w, "provider:\t%s\n", provider)
+	fmt.Fprintf(w, "model:\t%s\n", p.Model)
+	if len(p.Fallbacks) > 0 {
+		fmt.Fprintf(w, "fallbacks:\t%s\n", strings.Join(p.Fallbacks, ", "))
+	}
+	writeParams(w, "", p.Params)
+	if p.ContinueOnTruncate > 0 {
+		fmt.Fprintf(w, "continue_on_truncate:\t%d\n", p.ContinueOnTruncate)
+	}
+	if len(p.Safety) > 0 {
+		fmt.Fprintf(w, "safety:\t%s\n", p.Safety)
+	}
+	for _, v := range p.Variables {
+		value := "(required)"
+		if v.Default != nil {
+			value = fmt.Sprintf("default %q", *v.Default)
+		}
+		if v.Description != "" {
+			value += " " + v.Description
+		}
+		fmt.Fprintf(w, "var %s:\t%s\n", v.Name, value)
+	}
+	for _, t := range p.Tools {
+		status := t.Command
+		if !cfg.toolAllowed(t.Name) {
+			status = "not in allowed_tools"
+		}
+		fmt.Fprintf(w, "tool %s:\t%s\n", t.Name, status)
+	}
+	for _, name := range p.MCP {
+		s := cfg.MCPServers[name]
+		fmt.Fprintf(w, "mcp %s:\t%s\n", name, strings.Join(append([]string{s.Command}, s.Args...), " "))
+	}
+	w.Flush()
+	if p.System != "" {
+		fmt.Fprintf(out, "system:\n%s\n", strings.TrimRight(p.System, "\n"))
+	}
+	fmt.Fprintf(out, "prompt:\n%s\n", strings.TrimRight(p.Prompt, "\n"))
+}
+
+func cmdConfig(args []string, profile string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: pipellm config path|edit|validate")
+	}
+
+	path, err := ConfigPath()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "path":
+		fmt.Println(path)
+		return nil
+	case "edit":
+		if err := editFile(path); err != nil {
+			return err
+		}
+		return validateConfig(profile)
+	case "validate":
+		return validateConfig(profile)
+	}
+	return fmt.Errorf("unknown config command %q", args[0])
+}
+
+func cmdCache(args []string, profile string) error {
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: pipellm cache stats|clear")
+	}
+
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+	cache, err := OpenCache(cfg.Cache)
+	if err != nil {
+		return err
+	}
+
+	switch rest[0] {
+	case "stats":
+		stats, err := cache.Stats()
+		if err != nil {
+			return err
+		}
+		writeCacheStats(os.Stdout, stats)
+		return nil
+	case "clear":
+		n, err := cache.Clear()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d cached responses\n", n)
+		return nil
+	}
+	return fmt.Errorf("unknown cache command %q", rest[0])
+}
+
+func cmdUsage(args []string, profile string) error {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	since := fs.String("since", "30d", "Only count calls since `age` (7d, 12h) or date (2006-01-02)")
+	by := fs.String("by", "prompt", "Group by prompt or model")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("usage: pipellm usage [--since 7d] [--by prompt|model]")
+	}
+	if *by != "prompt" && *by != "model" {
+		return fmt.Errorf("unknown --by %q (want prompt or model)", *by)
+	}
+	start, err := parseSince(*since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+	path, err := ledgerPath()
+	if err != nil {
+		return err
+	}
+	entries, err := ReadUsage(path, start)
+	if err != nil {
+		return err
+	}
+
+	writeUsageReport(os.Stdout, *by, summarizeUsage(entries, *by, cfg.Prices))
+	return nil
+}
+
+func writeCacheStats(out io.Writer, s *CacheStats) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "dir:\t%s\n", s.Dir)
+	fmt.Fprintf(w, "entries:\t%d\n", s.Entries)
+	fmt.Fprintf(w, "size:\t%.1f MB\n", float64(s.Bytes)/(1<<20))
+	if s.Entries > 0 {
+		fmt.Fprintf(w, "least recently used:\t%s\n", s.Oldest.Format(time.DateTime))
+		fmt.Fprintf(w, "most recently used:\t%s\n", s.Newest.Format(time.DateTime))
+	}
+	w.Flush()
+}
+
+func editFile(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// Run through the shell so editors with arguments ("code -w") work.
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running editor: %w", err)
+	}
+	return nil
+}
+
+func validateConfig(profile string) error {
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	fmt.Printf("config OK: %d prompts, %d profiles\n", len(cfg.Prompts), len(cfg.Profiles))
+	return nil
+}
+
+func writeParams(w io.Writer, indent string, p Params) {
+	if p.Temperature != nil {
+		fmt.Fprintf(w, "%stemperature:\t%g\n", indent, *p.Temperature)
+	}
+	if p.TopP != nil {
+		fmt.Fprintf(w, "%stop_p:\t%g\n", indent, *p.TopP)
+	}
+	if p.TopK != nil {
+		fmt.Fprintf(w, "%stop_k:\t%d\n", indent, *p.TopK)
+	}
+	if p.MaxOutputTokens != nil {
+		fmt.Fprintf(w, "%smax_output_tokens:\t%d\n", indent, *p.MaxOutputTokens)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShowPromptProvider(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected string
+	}{
+		{"gemini-pro", "provider: gemini\n"},
+		{"local:llama3", "provider: ollama\n"},
+		{"vertex:gemini-1.5-pro", "provider: vertex\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		showPrompt(&out, &Config{}, &Prompt{Name: "p", Model: tt.model})
+		if !strings.Contains(out.String(), tt.expected) {
+			t.Errorf("Model %s: expected %q in:\n%s", tt.model, tt.expected, out.String())
+		}
+	}
+}
@@ -1,32 +1,104 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	APIKey  string   `yaml:"api_key"`
-	Model   string   `yaml:"model"`
-	Prompts []Prompt `yaml:"prompts"`
+	Provider      string               `yaml:"provider"`
+	APIKey        string               `yaml:"api_key"`
+	APIKeyEnv     string               `yaml:"api_key_env"`
+	APIKeyCommand string               `yaml:"api_key_command"`
+	Endpoint      string               `yaml:"endpoint"`
+	Endpoints     map[string]string    `yaml:"endpoints"` // by provider, for prefixed models
+	Project       string               `yaml:"project"`
+	Location      string               `yaml:"location"`
+	Model         string               `yaml:"-"` // first entry of model
+	Fallbacks     []string             `yaml:"-"` // remaining entries of model
+	Timeout       string               `yaml:"timeout"`
+	Params        Params               `yaml:",inline"`
+	Prompts       []Prompt             `yaml:"prompts"`
+	Profiles      map[string]Profile   `yaml:"profiles"`
+	Cache         CacheConfig          `yaml:"cache"`
+	Prices        map[string]Price     `yaml:"prices"`
+	Budget        Budget               `yaml:"budget"`
+	Safety        Safety               `yaml:"safety"`
+	AllowedTools  []string             `yaml:"allowed_tools"` // tools that may run; none without it
+	Sandbox       Sandbox              `yaml:"sandbox"`       // applies to tool commands
+	MCPServers    map[string]MCPServer `yaml:"mcp_servers"`
 }
 
 type Prompt struct {
-	Name   string `yaml:"name"`
-	Prompt string `yaml:"prompt"`
+	Name        string     `yaml:"name"`
+	Description string     `yaml:"description"`
+	System      string     `yaml:"system"`
+	Prompt      string     `yaml:"prompt"`
+	Model       string     `yaml:"-"`
+	Fallbacks   []string   `yaml:"-"`
+	Params      Params     `yaml:",inline"`
+	Budget      Budget     `yaml:"budget"`
+	Safety      Safety     `yaml:"safety"`
+	Variables   []Variable `yaml:"variables"`
+
+	// ContinueOnTruncate is how many times to ask for the rest of a
+	// response cut off at the output token limit.
+	ContinueOnTruncate int `yaml:"continue_on_truncate"`
+
+	Tools         []Tool   `yaml:"tools"`
+	ConfirmTools  bool     `yaml:"confirm_tools"`
+	MaxToolRounds int      `yaml:"max_tool_rounds"`
+	MCP           []string `yaml:"mcp"` // names of mcp_servers whose tools to offer
 }
 
-func LoadConfig() (*Config, error) {
+// Params are generation settings. Unset fields use the model defaults.
+type Params struct {
+	Temperature     *float32 `yaml:"temperature"`
+	TopP            *float32 `yaml:"top_p"`
+	TopK            *int32   `yaml:"top_k"`
+	MaxOutputTokens *int32   `yaml:"max_output_tokens"`
+}
+
+// Profile overrides the top-level provider settings, and optionally
+// individual prompts, when selected with --profile or PIPELLM_PROFILE.
+type Profile struct {
+	Provider      string   `yaml:"provider"`
+	APIKey        string   `yaml:"api_key"`
+	APIKeyEnv     string   `yaml:"api_key_env"`
+	APIKeyCommand string   `yaml:"api_key_command"`
+	Endpoint      string   `yaml:"endpoint"`
+	Project       string   `yaml:"project"`
+	Location      string   `yaml:"location"`
+	Model         string   `yaml:"-"`
+	Fallbacks     []string `yaml:"-"`
+	Params        Params   `yaml:",inline"`
+	Prompts       []Prompt `yaml:"prompts"`
+}
+
+func ConfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".pipellm.yaml"), nil
+}
+
+func LoadConfig() (*Config, error) {
+	configPath, err := ConfigPath()
 	if err != nil {
 		return nil, err
 	}
+	return readConfig(configPath)
+}
 
-	configPath := filepath.Join(homeDir, ".pipellm.yaml")
+func readConfig(configPath string) (*Config, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("config file not found at %s", configPath)
@@ -48,3 +120,225 @@ func (c *Config) FindPrompt(name string) string {
 	}
 	return ""
 }
+
+// ResolvePrompt returns a copy of the named prompt with the config-level
+// model, params and safety settings filled in where the prompt does not set its own.
+func (c *Config) ResolvePrompt(name string) *Prompt {
+	for _, p := range c.Prompts {
+		if strings.EqualFold(strings.TrimSpace(p.Name), strings.TrimSpace(name)) {
+			p.Name = strings.TrimSpace(p.Name)
+			if p.Model == "" {
+				p.Model, p.Fallbacks = c.Model, c.Fallbacks
+			}
+			if p.Model == "" {
+				p.Model = defaultModels[providerName(c)]
+			}
+			p.Params = p.Params.Merge(c.Params)
+			p.Safety = p.Safety.Merge(c.Safety)
+			return &p
+		}
+	}
+	return nil
+}
+
+// Validate reports problems that would make prompts unusable.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, ok := defaultModels[providerName(c)]; !ok {
+		errs = append(errs, fmt.Errorf("unknown provider %q", c.Provider))
+	}
+
+	budgets := c.Budget.isSet()
+	seen := make(map[string]bool)
+	for i, p := range c.Prompts {
+		name := strings.ToLower(strings.TrimSpace(p.Name))
+		switch {
+		case name == "":
+			errs = append(errs, fmt.Errorf("prompt #%d has no name", i+1))
+		case seen[name]:
+			errs = append(errs, fmt.Errorf("duplicate prompt name %q", p.Name))
+		}
+		seen[name] = true
+		if strings.TrimSpace(p.Prompt) == "" {
+			errs = append(errs, fmt.Errorf("prompt %q has no prompt text", p.Name))
+		}
+		budgets = budgets || p.Budget.isSet()
+		if p.ContinueOnTruncate < 0 {
+			errs = append(errs, fmt.Errorf("prompt %q: continue_on_truncate must not be negative", p.Name))
+		}
+		if err := p.Safety.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("prompt %q: %w", p.Name, err))
+		}
+		if err := validateVariables(p.Variables); err != nil {
+			errs = append(errs, fmt.Errorf("prompt %q: %w", p.Name, err))
+		}
+		tools := make(map[string]bool)
+		for _, t := range p.Tools {
+			if err := t.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("prompt %q: %w", p.Name, err))
+			} else if tools[t.Name] {
+				errs = append(errs, fmt.Errorf("prompt %q: duplicate tool %q", p.Name, t.Name))
+			}
+			tools[t.Name] = true
+		}
+		if p.MaxToolRounds < 0 {
+			errs = append(errs, fmt.Errorf("prompt %q: max_tool_rounds must not be negative", p.Name))
+		}
+		for _, name := range p.MCP {
+			if _, ok := c.MCPServers[name]; !ok {
+				errs = append(errs, fmt.Errorf("prompt %q: unknown mcp server %q", p.Name, name))
+			}
+		}
+	}
+
+	if err := c.Safety.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if budgets && len(c.Prices) == 0 {
+		errs = append(errs, fmt.Errorf("budgets need a prices table to estimate costs"))
+	}
+
+	if c.Timeout != "" {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("invalid timeout %q", c.Timeout))
+		}
+	}
+
+	if err := c.Cache.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Sandbox.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for name, s := range c.MCPServers {
+		if err := s.validate(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name := range c.Endpoints {
+		if _, ok := defaultModels[name]; !ok {
+			errs = append(errs, fmt.Errorf("endpoints: unknown provider %q", name))
+		}
+	}
+
+	for name, p := range c.Profiles {
+		if p.Provider == "" {
+			continue
+		}
+		if _, ok := defaultModels[strings.ToLower(p.Provider)]; !ok {
+			errs = append(errs, fmt.Errorf("profile %q: unknown provider %q", name, p.Provider))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyProfile merges the named profile into the config. Non-empty
+// profile fields replace the top-level ones; profile prompts replace
+// top-level prompts with the same name and are appended otherwise.
+// An empty name leaves the config unchanged.
+// requestTimeout returns the timeout for one model call, or 0 for none.
+func (c *Config) requestTimeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q", c.Timeout)
+	}
+	return d, nil
+}
+
+func (c *Config) ApplyProfile(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	// A key source in the profile replaces all top-level key sources,
+	// otherwise a top-level api_key would shadow the profile's api_key_env.
+	if p.APIKey != "" || p.APIKeyEnv != "" || p.APIKeyCommand != "" {
+		c.APIKey = p.APIKey
+		c.APIKeyEnv = p.APIKeyEnv
+		c.APIKeyCommand = p.APIKeyCommand
+	}
+
+	override(&c.Provider, p.Provider)
+	override(&c.Endpoint, p.Endpoint)
+	override(&c.Project, p.Project)
+	override(&c.Location, p.Location)
+	if p.Model != "" {
+		c.Model, c.Fallbacks = p.Model, p.Fallbacks
+	}
+	c.Params = p.Params.Merge(c.Params)
+
+	for _, pp := range p.Prompts {
+		replaced := false
+		for i := range c.Prompts {
+			if strings.EqualFold(strings.TrimSpace(c.Prompts[i].Name), strings.TrimSpace(pp.Name)) {
+				c.Prompts[i] = pp
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.Prompts = append(c.Prompts, pp)
+		}
+	}
+
+	return nil
+}
+
+// ResolveAPIKey returns the API key from the first configured source:
+// the literal api_key, the api_key_env variable or the api_key_command
+// output.
+func (c *Config) ResolveAPIKey() (string, error) {
+	switch {
+	case c.APIKey != "":
+		return c.APIKey, nil
+	case c.APIKeyEnv != "":
+		key := os.Getenv(c.APIKeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("environment variable %s is empty", c.APIKeyEnv)
+		}
+		return key, nil
+	case c.APIKeyCommand != "":
+		out, err := exec.Command("sh", "-c", c.APIKeyCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("api_key_command failed: %v", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", nil
+}
+
+// Merge returns p with unset fields taken from defaults.
+func (p Params) Merge(defaults Params) Params {
+	if p.Temperature == nil {
+		p.Temperature = defaults.Temperature
+	}
+	if p.TopP == nil {
+		p.TopP = defaults.TopP
+	}
+	if p.TopK == nil {
+		p.TopK = defaults.TopK
+	}
+	if p.MaxOutputTokens == nil {
+		p.MaxOutputTokens = defaults.MaxOutputTokens
+	}
+	return p
+}
+
+func override(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
@@ -215,3 +215,172 @@ func TestConfigFindPrompt(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigApplyProfile(t *testing.T) {
+	config := &Config{
+		APIKey: "top_key",
+		Model:  "gemini-pro",
+		Prompts: []Prompt{
+			{Name: "summary", Prompt: "Summarize"},
+			{Name: "review", Prompt: "Review"},
+		},
+		Profiles: map[string]Profile{
+			"local": {
+				Provider:  "ollama",
+				Endpoint:  "http://box:11434",
+				Model:     "llama3",
+				APIKeyEnv: "LOCAL_KEY",
+				Prompts: []Prompt{
+					{Name: "Review", Prompt: "Review briefly"},
+					{Name: "extra", Prompt: "Extra prompt"},
+				},
+			},
+		},
+	}
+
+	if err := config.ApplyProfile("local"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+
+	if config.Provider != "ollama" || config.Endpoint != "http://box:11434" || config.Model != "llama3" {
+		t.Errorf("Profile settings not applied: %+v", config)
+	}
+
+	// The profile key source replaces the top-level api_key
+	if config.APIKey != "" || config.APIKeyEnv != "LOCAL_KEY" {
+		t.Errorf("Expected key source from profile, got api_key=%q api_key_env=%q", config.APIKey, config.APIKeyEnv)
+	}
+
+	if got := config.FindPrompt("review"); got != "Review briefly" {
+		t.Errorf("Expected overridden prompt, got %q", got)
+	}
+	if got := config.FindPrompt("summary"); got != "Summarize" {
+		t.Errorf("Expected untouched prompt, got %q", got)
+	}
+	if got := config.FindPrompt("extra"); got != "Extra prompt" {
+		t.Errorf("Expected appended prompt, got %q", got)
+	}
+}
+
+func TestConfigApplyProfileUnknown(t *testing.T) {
+	config := &Config{Model: "gemini-pro"}
+
+	if err := config.ApplyProfile(""); err != nil {
+		t.Errorf("Empty profile should be a no-op, got %v", err)
+	}
+
+	err := config.ApplyProfile("missing")
+	if err == nil {
+		t.Fatal("Expected error for unknown profile, got nil")
+	}
+	if !strings.Contains(err.Error(), "unknown profile") {
+		t.Errorf("Expected 'unknown profile' error, got '%v'", err)
+	}
+}
+
+func TestConfigResolveAPIKey(t *testing.T) {
+	t.Setenv("PIPELLM_TEST_KEY", "env_key")
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+		wantErr  bool
+	}{
+		{name: "literal", config: Config{APIKey: "literal_key"}, expected: "literal_key"},
+		{name: "env", config: Config{APIKeyEnv: "PIPELLM_TEST_KEY"}, expected: "env_key"},
+		{name: "empty env", config: Config{APIKeyEnv: "PIPELLM_TEST_UNSET"}, wantErr: true},
+		{name: "command", config: Config{APIKeyCommand: "echo command_key"}, expected: "command_key"},
+		{name: "failing command", config: Config{APIKeyCommand: "exit 1"}, wantErr: true},
+		{name: "none", config: Config{}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.config.ResolveAPIKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if key != tt.expected {
+				t.Errorf("ResolveAPIKey() = %q, expected %q", key, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfigResolvePrompt(t *testing.T) {
+	temp := float32(0.3)
+	zero := float32(0)
+	config := &Config{
+		Model:  "gemini-2.5-flash",
+		Params: Params{Temperature: &temp},
+		Prompts: []Prompt{
+			{Name: " summary ", Prompt: "Summarize"},
+			{Name: "review", Prompt: "Review", Model: "gemini-2.5-pro", Params: Params{Temperature: &zero}},
+		},
+	}
+
+	p := config.ResolvePrompt("SUMMARY")
+	if p == nil {
+		t.Fatal("Expected prompt 'summary' to resolve")
+	}
+	if p.Name != "summary" || p.Model != "gemini-2.5-flash" {
+		t.Errorf("Expected config defaults, got name=%q model=%q", p.Name, p.Model)
+	}
+	if p.Params.Temperature == nil || *p.Params.Temperature != 0.3 {
+		t.Errorf("Expected inherited temperature 0.3, got %v", p.Params.Temperature)
+	}
+
+	p = config.ResolvePrompt("review")
+	if p.Model != "gemini-2.5-pro" {
+		t.Errorf("Expected prompt model override, got %q", p.Model)
+	}
+	if p.Params.Temperature == nil || *p.Params.Temperature != 0 {
+		t.Errorf("Expected prompt temperature 0, got %v", p.Params.Temperature)
+	}
+
+	if config.ResolvePrompt("missing") != nil {
+		t.Error("Expected nil for missing prompt")
+	}
+
+	// Without any model configured the provider default is used
+	config = &Config{Provider: "ollama", Prompts: []Prompt{{Name: "local", Prompt: "x"}}}
+	if p := config.ResolvePrompt("local"); p.Model != "llama3" {
+		t.Errorf("Expected provider default model, got %q", p.Model)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := &Config{Prompts: []Prompt{{Name: "a", Prompt: "A"}, {Name: "b", Prompt: "B"}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	invalid := &Config{
+		Provider: "openai",
+		Prompts: []Prompt{
+			{Name: "", Prompt: "No name"},
+			{Name: "dup", Prompt: "One"},
+			{Name: "DUP", Prompt: "Two"},
+			{Name: "empty", Prompt: "  "},
+		},
+		Profiles: map[string]Profile{"bad": {Provider: "nope"}},
+	}
+
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors, got nil")
+	}
+
+	for _, want := range []string{
+		`unknown provider "openai"`,
+		"prompt #1 has no name",
+		`duplicate prompt name "DUP"`,
+		`prompt "empty" has no prompt text`,
+		`profile "bad": unknown provider "nope"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
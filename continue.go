@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultContinuations is used when --continue-on-truncate has no count.
+const defaultContinuations = 3
+
+const continueInstruction = "Your previous answer was cut off. Continue exactly where it stopped, without repeating anything or adding commentary."
+
+// continueFlag is --continue-on-truncate, alone or with a count.
+type continueFlag struct {
+	n   int
+	set bool
+}
+
+func (f *continueFlag) String() string {
+	if f == nil || !f.set {
+		return ""
+	}
+	return strconv.Itoa(f.n)
+}
+
+func (f *continueFlag) IsBoolFlag() bool { return true }
+
+func (f *continueFlag) Set(s string) error {
+	switch s {
+	case "true":
+		f.n = defaultContinuations
+	case "false":
+		f.n = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("want a number of continuations")
+		}
+		f.n = n
+	}
+	f.set = true
+	return nil
+}
+
+// limit returns the flag's count if given, else the prompt's setting.
+func (f *continueFlag) limit(p *Prompt) int {
+	if f.set {
+		return f.n
+	}
+	return p.ContinueOnTruncate
+}
+
+// continueRequest asks for the rest of text, the truncated answer to req.
+func continueRequest(req *Request, text string) *Request {
+	next := *req
+	next.History = append(append([]Message(nil), req.History...),
+		req.turn(),
+		Message{Role: "model", Parts: []string{text}},
+	)
+	next.Parts, next.Results = []string{continueInstruction}, nil
+	return &next
+}
+
+// stitch only looks for overlaps of minOverlap to maxOverlap bytes;
+// shorter ones are likely accidental.
+const (
+	minOverlap = 8
+	maxOverlap = 2000
+)
+
+// stitch appends next to text, dropping the start of next if the model
+// repeated the end of text.
+func stitch(text, next string) string {
+	trimmed := strings.TrimLeft(next, " \t\n")
+	for n := min(len(text), len(trimmed), maxOverlap); n >= minOverlap; n-- {
+		if strings.HasSuffix(text, trimmed[:n]) {
+			return text + trimmed[n:]
+		}
+	}
+	return text + next
+}
+
+// continueTruncated issues follow-up turns while res was cut off at the
+// output token limit, up to limit times, and returns res with the parts
+// stitched together. Continuations go to the model that answered.
+func continueTruncated(ctx context.Context, cfg *Config, prompt *Prompt, req *Request, res *result, limit int, opts *runOptions) (*result, error) {
+	pinned := res.pin(prompt)
+	out, resp := *res, *res.resp
+	// A part may repeat the end of the text before it, so parts are
+	// passed on once stitched.
+	partOpts := *opts
+	partOpts.onText = nil
+	for i := 0; i < limit && resp.FinishReason == "MAX_TOKENS"; i++ {
+		part, err := generate(ctx, cfg, pinned, continueRequest(req, resp.Text), &partOpts)
+		if errors.Is(err, ErrTruncated) {
+			// Not even one more token fit.
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("continuing truncated response: %w", err)
+		}
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "continued truncated response (%d/%d)\n", i+1, limit)
+		}
+		before := len(resp.Text)
+		resp.Text = stitch(resp.Text, part.resp.Text)
+		if opts.onText != nil && len(resp.Text) > before {
+			opts.onText(resp.Text[before:])
+		}
+		resp.FinishReason = part.resp.FinishReason
+		resp.SafetyRatings = part.resp.SafetyRatings
+		resp.OtherParts = append(resp.OtherParts, part.resp.OtherParts...)
+		resp.Usage = resp.Usage.Add(part.resp.Usage)
+		out.cached = out.cached && part.cached
+	}
+	out.resp = &resp
+	return &out, nil
+}
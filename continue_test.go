@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStitch(t *testing.T) {
+	tests := []struct {
+		text, next, expected string
+	}{
+		{"func main() {\n\tfmt.Println(", "\"hi\")\n}", "func main() {\n\tfmt.Println(\"hi\")\n}"},
+		// The model repeated the last line before continuing.
+		{"1. First point\n2. Second point\n", "2. Second point\n3. Third point", "1. First point\n2. Second point\n3. Third point"},
+		{"1. First point\n2. Second", "\n2. Second point", "1. First point\n2. Second point"},
+		// Short matches are kept, they are probably not repetition.
+		{"the end of the", "the next", "the end of thethe next"},
+		{"", "text", "text"},
+	}
+	for _, tt := range tests {
+		if got := stitch(tt.text, tt.next); got != tt.expected {
+			t.Errorf("stitch(%q, %q): expected %q, got %q", tt.text, tt.next, tt.expected, got)
+		}
+	}
+}
+
+func TestContinueFlag(t *testing.T) {
+	prompt := &Prompt{ContinueOnTruncate: 2}
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{nil, 2},
+		{[]string{"--continue-on-truncate"}, defaultContinuations},
+		{[]string{"--continue-on-truncate=5"}, 5},
+		{[]string{"--continue-on-truncate=0"}, 0},
+		{[]string{"--continue-on-truncate=false"}, 0},
+	}
+	for _, tt := range tests {
+		var opts runOptions
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts.register(fs, "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.args, err)
+		}
+		if got := opts.continuation.limit(prompt); got != tt.expected {
+			t.Errorf("%q: expected limit %d, got %d", tt.args, tt.expected, got)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var opts runOptions
+	opts.register(fs, "")
+	if err := fs.Parse([]string{"--continue-on-truncate=-1"}); err == nil {
+		t.Error("Expected error for a negative count")
+	}
+}
+
+func TestContinueTruncated(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	replies := []string{"Part one, then part", "then part two, then part", "then part three."}
+	var requests []ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		reason := "length"
+		if len(requests) == len(replies) {
+			reason = "stop"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":             req.Model,
+			"message":           map[string]string{"role": "assistant", "content": replies[len(requests)-1]},
+			"done_reason":       reason,
+			"prompt_eval_count": 10,
+			"eval_count":        5,
+		})
+	}))
+	defer server.Close()
+
+	cfg := &Config{Provider: "ollama", Endpoint: server.URL}
+	prompt := &Prompt{Name: "review", Model: "big", Fallbacks: []string{"small"}}
+	req := &Request{Parts: []string{"Review this"}}
+
+	res, err := generate(context.Background(), cfg, prompt, req, &runOptions{})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	res, err = continueTruncated(context.Background(), cfg, prompt, req, res, 5, &runOptions{})
+	if err != nil {
+		t.Fatalf("continueTruncated failed: %v", err)
+	}
+
+	if expected := "Part one, then part two, then part three."; res.resp.Text != expected {
+		t.Errorf("Expected %q, got %q", expected, res.resp.Text)
+	}
+	if res.resp.FinishReason != "STOP" {
+		t.Errorf("Expected finish reason STOP, got %q", res.resp.FinishReason)
+	}
+	if res.resp.Usage.OutputTokens != 15 {
+		t.Errorf("Expected usage summed over 3 calls, got %+v", res.resp.Usage)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(requests))
+	}
+
+	// Each continuation carries the stitched answer so far.
+	last := requests[2]
+	if last.Model != "big" {
+		t.Errorf("Expected continuations to use the answering model, got %q", last.Model)
+	}
+	if n := len(last.Messages); n != 3 || last.Messages[1].Content != "Part one, then part two, then part" || last.Messages[2].Content != continueInstruction {
+		t.Errorf("Unexpected continuation messages: %+v", last.Messages)
+	}
+
+	// The limit stops further turns even if the answer is still cut off.
+	requests = nil
+	res, _ = generate(context.Background(), cfg, prompt, req, &runOptions{noCache: true})
+	res, err = continueTruncated(context.Background(), cfg, prompt, req, res, 1, &runOptions{noCache: true})
+	if err != nil {
+		t.Fatalf("continueTruncated failed: %v", err)
+	}
+	if len(requests) != 2 || res.resp.FinishReason != "MAX_TOKENS" {
+		t.Errorf("Expected 2 requests ending truncated, got %d ending %s", len(requests), res.resp.FinishReason)
+	}
+
+	// Streamed, only what each part adds is passed on.
+	requests = nil
+	var texts []string
+	opts := &runOptions{noCache: true, onText: func(text string) { texts = append(texts, text) }}
+	res, _ = generate(context.Background(), cfg, prompt, req, &runOptions{noCache: true})
+	if _, err = continueTruncated(context.Background(), cfg, prompt, req, res, 5, opts); err != nil {
+		t.Fatalf("continueTruncated failed: %v", err)
+	}
+	if strings.Join(texts, "|") != " two, then part| three." {
+		t.Errorf("Expected the stitched parts, got %q", texts)
+	}
+}
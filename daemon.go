@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// daemonSocket returns $PIPELLM_SOCKET, or pipellm.sock in
+// $XDG_RUNTIME_DIR or the state directory.
+func daemonSocket() (string, error) {
+	if path := os.Getenv("PIPELLM_SOCKET"); path != "" {
+		return path, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "pipellm.sock"), nil
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pipellm.sock"), nil
+}
+
+// unixClient returns an HTTP client that talks to the daemon on socket.
+func unixClient(socket string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+func cmdDaemon(args []string, profile string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	socket, err := daemonSocket()
+	if err != nil {
+		return err
+	}
+	client := unixClient(socket)
+
+	switch {
+	case len(rest) == 0:
+		return runDaemon(socket)
+	case len(rest) == 1 && rest[0] == "status":
+		var status daemonStatus
+		if err := daemonCall(client, "GET", "/status", nil, &status); err != nil {
+			return fmt.Errorf("daemon not running on %s", socket)
+		}
+		fmt.Printf("running on %s (pid %d, up %v, %d requests, %d clients)\n",
+			socket, status.PID, time.Since(status.Started).Round(time.Second), status.Requests, status.Clients)
+		return nil
+	case len(rest) == 1 && rest[0] == "stop":
+		if err := daemonCall(client, "POST", "/stop", nil, nil); err != nil {
+			return fmt.Errorf("daemon not running on %s", socket)
+		}
+		fmt.Println("stopped")
+		return nil
+	}
+	return fmt.Errorf("usage: pipellm daemon [status|stop]")
+}
+
+// daemonCall sends a request to the daemon and decodes the JSON answer
+// into out if it is not nil.
+func daemonCall(client *http.Client, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, "http://pipellm"+path, &body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("daemon: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func runDaemon(socket string) error {
+	if err := daemonCall(unixClient(socket), "GET", "/status", nil, nil); err == nil {
+		return fmt.Errorf("daemon already running on %s", socket)
+	}
+	// Nothing answers, so a socket left behind by a crash can go.
+	os.Remove(socket)
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+	ln, err := listenPrivate(socket)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	d := newDaemon(stop)
+	fmt.Fprintf(os.Stderr, "Daemon listening on %s\n", socket)
+	return serveHTTP(ctx, ownerListener{ln}, d)
+}
+
+// ownerListener drops connections from other users.
+type ownerListener struct {
+	net.Listener
+}
+
+func (l ownerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPeer(conn); err != nil {
+			fmt.Fprintf(os.Stderr, "Refused connection: %v\n", err)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// daemonTarget selects a backend and model. Together they identify a
+// client the daemon keeps.
+type daemonTarget struct {
+	Provider    string `json:"provider"`
+	Endpoint    string `json:"endpoint,omitempty"`
+	Project     string `json:"project,omitempty"`
+	Location    string `json:"location,omitempty"`
+	APIKey      string `json:"api_key,omitempty"`
+	Credentials string `json:"credentials,omitempty"` // $GOOGLE_APPLICATION_CREDENTIALS for Vertex
+	Model       string `json:"model"`
+}
+
+// newDaemonTarget returns the target for model with cfg's settings.
+// What comes from the environment is resolved here, as the daemon's may
+// differ: it never runs api_key_command and sees the key, and the
+// Vertex project and credentials, of the current environment.
+func newDaemonTarget(cfg *Config, model string) (daemonTarget, error) {
+	name, _ := splitModel(cfg, model)
+	cfg = providerConfig(cfg, name)
+	t := daemonTarget{
+		Provider: providerName(cfg),
+		Endpoint: cfg.Endpoint,
+		Project:  cfg.Project,
+		Location: cfg.Location,
+		Model:    model,
+	}
+	switch name {
+	case "gemini":
+		key, err := cfg.ResolveAPIKey()
+		if err != nil {
+			return t, err
+		}
+		t.APIKey = key
+	case "vertex":
+		project, err := vertexProject(t.Project)
+		if err != nil {
+			return t, err
+		}
+		t.Project = project
+		t.Credentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	return t, nil
+}
+
+// local reports whether the daemon would call t with the credentials
+// the CLI would use. Vertex finds them through the environment, which
+// the daemon cannot switch for one client.
+func (t *daemonTarget) local() bool {
+	return t.Provider != "vertex" || t.Credentials == os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+func (t *daemonTarget) config() *Config {
+	return &Config{
+		Provider: t.Provider,
+		Endpoint: t.Endpoint,
+		Project:  t.Project,
+		Location: t.Location,
+		APIKey:   t.APIKey,
+	}
+}
+
+type daemonRequest struct {
+	Target  daemonTarget `json:"target"`
+	Request *Request     `json:"request"`
+	Stream  bool         `json:"stream,omitempty"`
+}
+
+// daemonResponse is the answer to a daemonRequest. Streamed, the daemon
+// writes one per line: one for each piece of text, then the last.
+type daemonResponse struct {
+	Text     string     `json:"text,omitempty"`
+	Response *Response  `json:"response,omitempty"`
+	Error    *wireError `json:"error,omitempty"`
+	Direct   bool       `json:"direct,omitempty"` // the CLI is to make the call itself
+}
+
+// daemonConfig is the answer to GET /config.
+type daemonConfig struct {
+	Config *Config `json:"config,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+type daemonStatus struct {
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Requests int       `json:"requests"`
+	Clients  int       `json:"clients"`
+}
+
+// wireError carries a provider error from the daemon to the CLI with
+// what errors.Is and errors.As look for: its kind and the response
+// details, the API status and whether it was a timeout.
+type wireError struct {
+	Message  string         `json:"message"`
+	Kind     string         `json:"kind,omitempty"` // blocked, truncated or empty
+	Response *ResponseError `json:"response,omitempty"`
+	Status   int            `json:"status,omitempty"` // HTTP status of API errors
+	Timeout  bool           `json:"timeout,omitempty"`
+}
+
+var wireErrorKinds = map[string]error{"blocked": ErrBlocked, "truncated": ErrTruncated, "empty": ErrEmpty}
+
+func newWireError(err error) *wireError {
+	e := &wireError{Message: err.Error(), Timeout: errors.Is(err, context.DeadlineExceeded)}
+	for kind, target := range wireErrorKinds {
+		if errors.Is(err, target) {
+			e.Kind = kind
+		}
+	}
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		e.Response = respErr
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		e.Status = apiErr.StatusCode
+	}
+	if e.Status == 0 && e.Kind == "" && !e.Timeout && shouldFallback(err) {
+		// Other retryable errors, such as those of the Gemini SDK.
+		e.Status = http.StatusServiceUnavailable
+	}
+	return e
+}
+
+func (e *wireError) Error() string { return e.Message }
+
+func (e *wireError) Unwrap() []error {
+	var errs []error
+	if kind, ok := wireErrorKinds[e.Kind]; ok {
+		if e.Response != nil {
+			resp := *e.Response
+			resp.Kind = kind
+			errs = append(errs, &resp)
+		} else {
+			errs = append(errs, kind)
+		}
+	}
+	if e.Status != 0 {
+		errs = append(errs, &APIError{StatusCode: e.Status, Message: e.Message})
+	}
+	if e.Timeout {
+		errs = append(errs, context.DeadlineExceeded)
+	}
+	return errs
+}
+
+// daemon keeps provider clients, and the connections they hold, and
+// parsed config files for the CLI to use.
+type daemon struct {
+	stop    func()
+	started time.Time
+
+	mu        sync.Mutex
+	providers map[daemonTarget]Provider
+	configs   map[string]*keptConfig // by path
+	requests  int
+}
+
+// keptConfig is a parsed config file and the state of the file it was
+// read from.
+type keptConfig struct {
+	modTime time.Time
+	size    int64
+	cfg     *Config
+}
+
+func newDaemon(stop func()) *daemon {
+	return &daemon{
+		stop:      stop,
+		started:   time.Now(),
+		providers: make(map[daemonTarget]Provider),
+		configs:   make(map[string]*keptConfig),
+	}
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method + " " + r.URL.Path {
+	case "POST /generate":
+		d.generate(w, r)
+	case "GET /config":
+		var out daemonConfig
+		cfg, err := d.config(r.URL.Query().Get("path"))
+		if err != nil {
+			out.Error = err.Error()
+		}
+		out.Config = cfg
+		writeJSON(w, http.StatusOK, out)
+	case "GET /status":
+		d.mu.Lock()
+		status := daemonStatus{PID: os.Getpid(), Started: d.started, Requests: d.requests, Clients: len(d.providers)}
+		d.mu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+	case "POST /stop":
+		writeJSON(w, http.StatusOK, map[string]any{})
+		d.stop()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *daemon) provider(t daemonTarget) (Provider, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests++
+	if p, ok := d.providers[t]; ok {
+		return p, nil
+	}
+	p, err := NewProvider(t.config(), t.Model)
+	if err != nil {
+		return nil, err
+	}
+	d.providers[t] = p
+	return p, nil
+}
+
+// config returns the config file at path, reading it again only once
+// its modification time or size change.
+func (d *daemon) config(path string) (*Config, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return readConfig(path)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if kept, ok := d.configs[path]; ok && kept.modTime.Equal(info.ModTime()) && kept.size == info.Size() {
+		return kept.cfg, nil
+	}
+	cfg, err := readConfig(path)
+	if err != nil {
+		delete(d.configs, path)
+		return nil, err
+	}
+	d.configs[path] = &keptConfig{modTime: info.ModTime(), size: info.Size(), cfg: cfg}
+	return cfg, nil
+}
+
+func (d *daemon) generate(w http.ResponseWriter, r *http.Request) {
+	var req daemonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !req.Target.local() {
+		writeJSON(w, http.StatusOK, daemonResponse{Direct: true})
+		return
+	}
+	var out daemonResponse
+	p, err := d.provider(req.Target)
+	if err == nil {
+		streamer, ok := p.(Streamer)
+		if req.Stream && ok {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(w)
+			flusher, _ := w.(http.Flusher)
+			out.Response, err = streamer.GenerateStream(r.Context(), req.Request, func(text string) {
+				enc.Encode(daemonResponse{Text: text})
+				if flusher != nil {
+					flusher.Flush()
+				}
+			})
+		} else {
+			out.Response, err = p.Generate(r.Context(), req.Request)
+		}
+	}
+	if err != nil {
+		out.Response, out.Error = nil, newWireError(err)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// daemonProvider sends calls to the daemon, or makes them directly if
+// no daemon answers.
+type daemonProvider struct {
+	client *http.Client
+	target daemonTarget
+	direct func() (Provider, error)
+}
+
+func (p *daemonProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return p.call(ctx, req, nil)
+}
+
+func (p *daemonProvider) GenerateStream(ctx context.Context, req *Request, onText func(string)) (*Response, error) {
+	return p.call(ctx, req, onText)
+}
+
+// call asks the daemon to generate, streaming unless onText is nil.
+func (p *daemonProvider) call(ctx context.Context, req *Request, onText func(string)) (*Response, error) {
+	data, err := json.Marshal(daemonRequest{Target: p.target, Request: req, Stream: onText != nil})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://pipellm/generate", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(httpReq)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return p.callDirect(ctx, req, onText)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("daemon: %s", resp.Status)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var out daemonResponse
+		if err := dec.Decode(&out); err != nil {
+			return nil, fmt.Errorf("daemon: %w", err)
+		}
+		switch {
+		case out.Direct:
+			return p.callDirect(ctx, req, onText)
+		case out.Error != nil:
+			return nil, out.Error
+		case out.Response != nil:
+			return out.Response, nil
+		case onText != nil:
+			onText(out.Text)
+		}
+	}
+}
+
+// callDirect makes the call without the daemon.
+func (p *daemonProvider) callDirect(ctx context.Context, req *Request, onText func(string)) (*Response, error) {
+	direct, err := p.direct()
+	if err != nil {
+		return nil, err
+	}
+	if streamer, ok := direct.(Streamer); ok && onText != nil {
+		return streamer.GenerateStream(ctx, req, onText)
+	}
+	return direct.Generate(ctx, req)
+}
+
+// runningDaemon returns the daemon's socket if it exists, unless
+// PIPELLM_NO_DAEMON is set.
+func runningDaemon() (string, bool) {
+	socket, err := daemonSocket()
+	if err != nil || os.Getenv("PIPELLM_NO_DAEMON") != "" {
+		return "", false
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode()&os.ModeSocket == 0 {
+		return "", false
+	}
+	return socket, true
+}
+
+// connectConfig is LoadConfig, but takes the parsed config from the
+// daemon if one runs.
+func connectConfig() (*Config, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	socket, ok := runningDaemon()
+	if !ok {
+		return readConfig(path)
+	}
+	var out daemonConfig
+	if err := daemonCall(unixClient(socket), "GET", "/config?path="+url.QueryEscape(path), nil, &out); err != nil {
+		return readConfig(path)
+	}
+	if out.Error != "" {
+		return nil, errors.New(out.Error)
+	}
+	return out.Config, nil
+}
+
+// connectProvider is NewProvider, but goes through the daemon if one
+// runs.
+func connectProvider(cfg *Config, model string) (Provider, error) {
+	socket, ok := runningDaemon()
+	if !ok {
+		return NewProvider(cfg, model)
+	}
+
+	target, err := newDaemonTarget(cfg, model)
+	if err != nil {
+		return nil, err
+	}
+	return &daemonProvider{
+		client: unixClient(socket),
+		target: target,
+		direct: func() (Provider, error) { return NewProvider(cfg, model) },
+	}, nil
+}
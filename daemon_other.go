@@ -0,0 +1,36 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenPrivate listens on socket. Without a way to check peers, the
+// socket's directory must keep other users out.
+func listenPrivate(socket string) (net.Listener, error) {
+	dir := filepath.Dir(socket)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%s must only be accessible to you (chmod 700)", dir)
+	}
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func checkPeer(conn net.Conn) error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startTestDaemon runs a daemon on a socket in a temporary directory and
+// points the CLI at it.
+func startTestDaemon(t *testing.T) (*daemon, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "pipellm.sock")
+	t.Setenv("PIPELLM_SOCKET", socket)
+	t.Setenv("PIPELLM_NO_DAEMON", "")
+	ln, err := listenPrivate(socket)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	d := newDaemon(stop)
+	done := make(chan error, 1)
+	go func() { done <- serveHTTP(ctx, ownerListener{ln}, d) }()
+	t.Cleanup(func() {
+		stop()
+		<-done
+	})
+	return d, socket
+}
+
+func TestDaemonForwardsCalls(t *testing.T) {
+	d, socket := startTestDaemon(t)
+	backend := &fakeOllama{reply: "Warm."}
+	ollama := httptest.NewServer(backend)
+	defer ollama.Close()
+	cfg := &Config{Provider: "ollama", Endpoint: ollama.URL}
+
+	for range 2 {
+		p, err := connectProvider(cfg, "llama3")
+		if err != nil {
+			t.Fatalf("connectProvider failed: %v", err)
+		}
+		if _, ok := p.(*daemonProvider); !ok {
+			t.Fatalf("Expected a daemon provider, got %T", p)
+		}
+		resp, err := p.Generate(context.Background(), &Request{Parts: []string{"hi"}})
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if resp.Text != "Warm." || resp.Usage.OutputTokens != 5 {
+			t.Errorf("Unexpected response %+v", resp)
+		}
+	}
+	if len(backend.requests) != 2 || backend.requests[0].Model != "llama3" {
+		t.Errorf("Expected 2 requests for llama3, got %+v", backend.requests)
+	}
+
+	var status daemonStatus
+	if err := daemonCall(unixClient(socket), "GET", "/status", nil, &status); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.Requests != 2 || status.Clients != 1 || status.PID != os.Getpid() {
+		t.Errorf("Expected one client for two requests, got %+v", status)
+	}
+	if len(d.providers) != 1 {
+		t.Errorf("Expected the client to be kept, got %d", len(d.providers))
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm()&0o077 != 0 {
+		t.Errorf("Expected a socket only the owner can use, got %v, %v", info.Mode(), err)
+	}
+}
+
+func TestDaemonStreams(t *testing.T) {
+	startTestDaemon(t)
+	backend := &fakeOllama{reply: "Warm and dry."}
+	ollama := httptest.NewServer(backend)
+	defer ollama.Close()
+
+	p, err := connectProvider(&Config{Provider: "ollama", Endpoint: ollama.URL}, "llama3")
+	if err != nil {
+		t.Fatalf("connectProvider failed: %v", err)
+	}
+	var texts []string
+	resp, err := p.(Streamer).GenerateStream(context.Background(), &Request{Parts: []string{"hi"}}, func(text string) {
+		texts = append(texts, text)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream failed: %v", err)
+	}
+	if len(texts) != 3 || resp.Text != "Warm and dry." {
+		t.Errorf("Expected the text in three pieces, got %q and %+v", texts, resp)
+	}
+
+	backend.fail = true
+	if _, err := p.(Streamer).GenerateStream(context.Background(), &Request{Parts: []string{"hi"}}, func(string) {}); err == nil {
+		t.Error("Expected the error to be streamed back")
+	}
+}
+
+func TestDaemonTargetResolvesKey(t *testing.T) {
+	cfg := &Config{APIKeyCommand: "echo k3y"}
+	target, err := newDaemonTarget(cfg, "gemini-2.5-flash")
+	if err != nil {
+		t.Fatalf("newDaemonTarget failed: %v", err)
+	}
+	// The daemon gets the key, never a command to run.
+	if target.APIKey != "k3y" || target.config().APIKeyCommand != "" {
+		t.Errorf("Expected the resolved key, got %+v", target)
+	}
+
+	target, err = newDaemonTarget(&Config{APIKeyCommand: "exit 1", Endpoints: map[string]string{"ollama": "http://homebox:11434"}}, "local:llama3")
+	if err != nil || target.APIKey != "" || target.Provider != "ollama" || target.Endpoint != "http://homebox:11434" {
+		t.Errorf("Expected an Ollama target without a key, got %+v, %v", target, err)
+	}
+}
+
+func TestDaemonKeepsConfig(t *testing.T) {
+	d, _ := startTestDaemon(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := filepath.Join(home, ".pipellm.yaml")
+	os.WriteFile(path, []byte(`
+model: [gemini-2.5-pro, local:llama3]
+temperature: 0.2
+safety: {harassment: only_high}
+allowed_tools: [grep_repo]
+profiles:
+  local: {provider: ollama, model: llama3}
+prompts:
+- name: review
+  prompt: Review this.
+  model: gemini-2.5-flash
+  variables: [{name: language, default: Go}]
+  tools:
+  - name: grep_repo
+    command: grep -rn x .
+    parameters: {type: object, properties: {pattern: {type: string}}}
+`), 0o600)
+
+	cfg, err := loadConfig("local")
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	direct, _ := readConfig(path)
+	direct.ApplyProfile("local")
+	if !reflect.DeepEqual(cfg, direct) {
+		t.Errorf("Expected the config as read directly, got\n%+v\nwant\n%+v", cfg, direct)
+	}
+	kept := d.configs[path]
+	if kept == nil {
+		t.Fatal("Expected the daemon to keep the config")
+	}
+
+	// The same file is not read again; a changed one is.
+	loadConfig("")
+	if d.configs[path] != kept {
+		t.Error("Expected the kept config to be reused")
+	}
+	os.WriteFile(path, []byte("model: gemini-2.5-flash\n"), 0o600)
+	os.Chtimes(path, time.Time{}, kept.modTime.Add(time.Second))
+	if cfg, err = loadConfig(""); err != nil || cfg.Model != "gemini-2.5-flash" || len(cfg.Prompts) != 0 {
+		t.Errorf("Expected the changed config, got %+v, %v", cfg, err)
+	}
+
+	os.Remove(path)
+	if _, err := loadConfig(""); err == nil || err.Error() != "loading config: config file not found at "+path {
+		t.Errorf("Expected the usual error for a missing file, got %v", err)
+	}
+}
+
+func TestDaemonVertexEnvironment(t *testing.T) {
+	_, socket := startTestDaemon(t)
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "cli-project")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/cli/key.json")
+	target, err := newDaemonTarget(&Config{Provider: "vertex"}, "gemini-2.5-pro")
+	if err != nil {
+		t.Fatalf("newDaemonTarget failed: %v", err)
+	}
+	if target.Project != "cli-project" || target.Credentials != "/cli/key.json" {
+		t.Errorf("Expected the CLI's project and credentials, got %+v", target)
+	}
+	if target, _ := newDaemonTarget(&Config{Provider: "vertex", Project: "p"}, "gemini-2.5-pro"); target.Project != "p" {
+		t.Errorf("Expected the configured project, got %+v", target)
+	}
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	if _, err := newDaemonTarget(&Config{Provider: "vertex"}, "gemini-2.5-pro"); err == nil {
+		t.Error("Expected an error without a project")
+	}
+
+	// The daemon runs in this process, so other credentials than its
+	// own are those of another environment: the CLI calls directly.
+	target.Credentials = "/other/key.json"
+	directCalls := 0
+	p := &daemonProvider{client: unixClient(socket), target: target, direct: func() (Provider, error) {
+		directCalls++
+		return &fakeProvider{reply: "direct"}, nil
+	}}
+	resp, err := p.Generate(context.Background(), &Request{Parts: []string{"hi"}})
+	if err != nil || resp.Text != "direct ()" || directCalls != 1 {
+		t.Errorf("Expected a direct call, got %+v, %v after %d", resp, err, directCalls)
+	}
+}
+
+func TestDaemonErrors(t *testing.T) {
+	startTestDaemon(t)
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"overloaded"}`, http.StatusServiceUnavailable)
+	}))
+	defer ollama.Close()
+
+	p, err := connectProvider(&Config{Provider: "ollama", Endpoint: ollama.URL}, "llama3")
+	if err != nil {
+		t.Fatalf("connectProvider failed: %v", err)
+	}
+	_, err = p.Generate(context.Background(), &Request{Parts: []string{"hi"}})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || !shouldFallback(err) {
+		t.Errorf("Expected a 503 API error to fall back on, got %v", err)
+	}
+}
+
+func TestWireError(t *testing.T) {
+	tests := []struct {
+		err      error
+		is       error
+		status   int
+		fallback bool
+	}{
+		{fmt.Errorf("gemini: %w", ErrBlocked), ErrBlocked, 0, true},
+		{fmt.Errorf("gemini: %w", ErrTruncated), ErrTruncated, 0, false},
+		{&APIError{Provider: "ollama", StatusCode: 429, Message: "slow down"}, nil, 429, true},
+		{&APIError{Provider: "ollama", StatusCode: 404, Message: "no model"}, nil, 404, false},
+		{fmt.Errorf("request: %w", context.DeadlineExceeded), context.DeadlineExceeded, 0, true},
+	}
+	for _, tt := range tests {
+		e := newWireError(tt.err)
+		if e.Error() != tt.err.Error() {
+			t.Errorf("Expected message %q, got %q", tt.err, e)
+		}
+		if tt.is != nil && !errors.Is(e, tt.is) {
+			t.Errorf("%v: expected errors.Is %v", tt.err, tt.is)
+		}
+		var apiErr *APIError
+		if errors.As(e, &apiErr) != (tt.status != 0) || tt.status != 0 && apiErr.StatusCode != tt.status {
+			t.Errorf("%v: expected status %d, got %+v", tt.err, tt.status, apiErr)
+		}
+		if shouldFallback(e) != tt.fallback {
+			t.Errorf("%v: expected shouldFallback %v", tt.err, tt.fallback)
+		}
+	}
+
+	// The details of a response error survive the trip to the CLI.
+	blocked := &ResponseError{Kind: ErrBlocked, Provider: "Vertex", BlockReason: "SAFETY"}
+	data, err := json.Marshal(newWireError(fmt.Errorf("calling vertex API: %w", blocked)))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var e wireError
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	var respErr *ResponseError
+	if !errors.As(&e, &respErr) || respErr.BlockReason != "SAFETY" || !errors.Is(&e, ErrBlocked) {
+		t.Errorf("Expected the blocked response to survive, got %+v", respErr)
+	}
+}
+
+func TestConnectProviderWithoutDaemon(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "pipellm.sock")
+	t.Setenv("PIPELLM_SOCKET", socket)
+	t.Setenv("PIPELLM_NO_DAEMON", "")
+	backend := &fakeOllama{reply: "Direct."}
+	ollama := httptest.NewServer(backend)
+	defer ollama.Close()
+	cfg := &Config{Provider: "ollama", Endpoint: ollama.URL}
+
+	// No socket at all.
+	if p, _ := connectProvider(cfg, "llama3"); p == nil {
+		t.Fatal("Expected a provider")
+	} else if _, ok := p.(*daemonProvider); ok {
+		t.Errorf("Expected a direct provider without a socket")
+	}
+
+	// A socket nobody listens on, as a crashed daemon leaves behind.
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	p, err := connectProvider(cfg, "llama3")
+	if err != nil {
+		t.Fatalf("connectProvider failed: %v", err)
+	}
+	resp, err := p.Generate(context.Background(), &Request{Parts: []string{"hi"}})
+	if err != nil || resp.Text != "Direct." {
+		t.Errorf("Expected a direct call, got %+v, %v", resp, err)
+	}
+
+	t.Setenv("PIPELLM_NO_DAEMON", "1")
+	if p, _ := connectProvider(cfg, "llama3"); p == nil {
+		t.Fatal("Expected a provider")
+	} else if _, ok := p.(*daemonProvider); ok {
+		t.Errorf("Expected PIPELLM_NO_DAEMON to skip the daemon")
+	}
+}
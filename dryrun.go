@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// estimateTokens approximates the input token count of req offline,
+// using the common rule of thumb of about four characters per token.
+func estimateTokens(req *Request) int {
+	chars := utf8.RuneCountInString(req.System)
+	for _, m := range req.History {
+		for _, p := range m.Parts {
+			chars += utf8.RuneCountInString(p)
+		}
+		for _, r := range m.Results {
+			chars += utf8.RuneCountInString(r.Output)
+		}
+	}
+	for _, p := range req.Parts {
+		chars += utf8.RuneCountInString(p)
+	}
+	for _, r := range req.Results {
+		chars += utf8.RuneCountInString(r.Output)
+	}
+	return (chars + 3) / 4
+}
+
+func writeDryRun(out io.Writer, provider, model string, req *Request) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "provider:\t%s\n", provider)
+	fmt.Fprintf(w, "model:\t%s\n", model)
+	w.Flush()
+
+	fmt.Fprintln(out, "generation config:")
+	if req.Params == (Params{}) {
+		fmt.Fprintln(out, "  (model defaults)")
+	} else {
+		w = tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+		writeParams(w, "  ", req.Params)
+		w.Flush()
+	}
+
+	if len(req.Safety) > 0 {
+		fmt.Fprintf(out, "safety: %s\n", req.Safety)
+	}
+	if len(req.Tools) > 0 {
+		names := make([]string, len(req.Tools))
+		for i, t := range req.Tools {
+			names[i] = t.Name
+		}
+		fmt.Fprintf(out, "tools: %s\n", strings.Join(names, ", "))
+	}
+	if req.System != "" {
+		fmt.Fprintf(out, "system instruction:\n%s\n", strings.TrimRight(req.System, "\n"))
+	}
+	if len(req.History) > 0 {
+		fmt.Fprintf(out, "history: %d earlier messages\n", len(req.History))
+	}
+	for i, p := range req.Parts {
+		fmt.Fprintf(out, "part %d (%d chars):\n%s\n", i+1, utf8.RuneCountInString(p), p)
+	}
+	fmt.Fprintf(out, "estimated input tokens: ~%d\n", estimateTokens(req))
+}
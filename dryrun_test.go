@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteDryRun(t *testing.T) {
+	temp := float32(0.2)
+	maxTokens := int32(256)
+	prompt := &Prompt{
+		System: "You are terse.",
+		Prompt: "Summarize",
+		Params: Params{Temperature: &temp, MaxOutputTokens: &maxTokens},
+	}
+
+	var buf bytes.Buffer
+	writeDryRun(&buf, "gemini", "gemini-2.5-flash", NewRequest(prompt, "Some input"))
+	out := buf.String()
+
+	for _, want := range []string{
+		"provider: gemini\n",
+		"model:    gemini-2.5-flash\n",
+		"  temperature:       0.2\n",
+		"  max_output_tokens: 256\n",
+		"system instruction:\nYou are terse.\n",
+		"part 1 (21 chars):\nSummarize\n\nSome input\n",
+		"estimated input tokens: ~9\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected dry run output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteDryRunDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	writeDryRun(&buf, "ollama", "llama3", NewRequest(&Prompt{Prompt: "Hi"}, ""))
+
+	if !strings.Contains(buf.String(), "generation config:\n  (model defaults)\n") {
+		t.Errorf("Expected model defaults note, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "system instruction") {
+		t.Errorf("Unexpected system instruction in output:\n%s", buf.String())
+	}
+}
+
+func TestMarshalRequest(t *testing.T) {
+	temp := float32(0)
+	req := NewRequest(&Prompt{System: "Be brief.", Prompt: "Review", Params: Params{Temperature: &temp}}, "code")
+
+	data, err := MarshalRequest("gemini", "gemini-2.5-flash", req)
+	if err != nil {
+		t.Fatalf("MarshalRequest failed: %v", err)
+	}
+	var gemini map[string]any
+	if err := json.Unmarshal(data, &gemini); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	for _, key := range []string{"systemInstruction", "contents", "generationConfig"} {
+		if _, ok := gemini[key]; !ok {
+			t.Errorf("Expected key %q in Gemini request:\n%s", key, data)
+		}
+	}
+	if !strings.Contains(string(data), `"temperature": 0`) {
+		t.Errorf("Expected explicit zero temperature in Gemini request:\n%s", data)
+	}
+
+	data, err = MarshalRequest("ollama", "llama3", req)
+	if err != nil {
+		t.Fatalf("MarshalRequest failed: %v", err)
+	}
+	var ollama ollamaChatRequest
+	if err := json.Unmarshal(data, &ollama); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if ollama.Model != "llama3" || len(ollama.Messages) != 2 || ollama.Messages[0].Role != "system" {
+		t.Errorf("Unexpected Ollama request: %+v", ollama)
+	}
+
+	if _, err := MarshalRequest("openai", "gpt", req); err == nil {
+		t.Error("Expected error for unknown provider, got nil")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of ResponseError, for use with errors.Is.
+var (
+	ErrBlocked   = errors.New("response blocked")
+	ErrTruncated = errors.New("response truncated")
+	ErrEmpty     = errors.New("empty response")
+)
+
+// blockedFinishReasons are the Gemini finish reasons that mean the
+// response was withheld by a content filter.
+var blockedFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
+func providerTitle(provider string) string {
+	switch provider {
+	case "gemini":
+		return "Gemini"
+	case "vertex":
+		return "Vertex"
+	case "ollama":
+		return "Ollama"
+	}
+	return provider
+}
+
+// ResponseError is returned when a provider answers without usable text.
+type ResponseError struct {
+	Kind          error          `json:"-"`        // ErrBlocked, ErrTruncated or ErrEmpty
+	Provider      string         `json:"provider"` // display name, e.g. "Gemini"
+	FinishReason  string         `json:"finish_reason,omitempty"`
+	BlockReason   string         `json:"block_reason,omitempty"` // set when the prompt itself was blocked
+	SafetyRatings []SafetyRating `json:"safety_ratings,omitempty"`
+	Parts         []string       `json:"parts,omitempty"` // kinds of non-text parts that were returned instead
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Kind
+}
+
+func (e *ResponseError) Error() string {
+	var msg string
+	switch {
+	case e.Kind == ErrBlocked && e.BlockReason != "":
+		msg = fmt.Sprintf("%s blocked the prompt (%s)", e.Provider, e.BlockReason)
+	case e.Kind == ErrBlocked:
+		msg = fmt.Sprintf("%s blocked the response (%s)", e.Provider, e.FinishReason)
+	case e.Kind == ErrTruncated:
+		msg = fmt.Sprintf("response from %s was cut off at the output token limit", e.Provider)
+	case len(e.Parts) > 0:
+		msg = fmt.Sprintf("no response from %s: only non-text parts (%s)", e.Provider, strings.Join(e.Parts, ", "))
+	case e.FinishReason != "" && e.FinishReason != "STOP":
+		msg = fmt.Sprintf("no response from %s (finish reason %s)", e.Provider, e.FinishReason)
+	default:
+		msg = fmt.Sprintf("no response from %s", e.Provider)
+	}
+
+	var flagged []string
+	for _, r := range e.SafetyRatings {
+		if r.Blocked || r.Probability == "MEDIUM" || r.Probability == "HIGH" {
+			flagged = append(flagged, fmt.Sprintf("%s %s", strings.TrimPrefix(r.Category, "HARM_CATEGORY_"), r.Probability))
+		}
+	}
+	if len(flagged) > 0 {
+		msg += ": " + strings.Join(flagged, ", ")
+	}
+	return msg
+}
+
+// checkFinish classifies a response that has no text.
+func checkFinish(provider, finishReason string, ratings []SafetyRating) *ResponseError {
+	err := &ResponseError{Kind: ErrEmpty, Provider: provider, FinishReason: finishReason, SafetyRatings: ratings}
+	switch {
+	case blockedFinishReasons[finishReason]:
+		err.Kind = ErrBlocked
+	case finishReason == "MAX_TOKENS":
+		err.Kind = ErrTruncated
+	}
+	return err
+}
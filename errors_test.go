@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func TestResponseErrorMessage(t *testing.T) {
+	tests := []struct {
+		err      *ResponseError
+		expected string
+	}{
+		{
+			&ResponseError{Kind: ErrBlocked, Provider: "Gemini", BlockReason: "SAFETY", SafetyRatings: []SafetyRating{
+				{Category: "HARM_CATEGORY_HARASSMENT", Probability: "NEGLIGIBLE"},
+				{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "HIGH", Blocked: true},
+			}},
+			"Gemini blocked the prompt (SAFETY): DANGEROUS_CONTENT HIGH",
+		},
+		{&ResponseError{Kind: ErrBlocked, Provider: "Vertex", FinishReason: "RECITATION"}, "Vertex blocked the response (RECITATION)"},
+		{&ResponseError{Kind: ErrTruncated, Provider: "Ollama", FinishReason: "MAX_TOKENS"}, "response from Ollama was cut off at the output token limit"},
+		{&ResponseError{Kind: ErrEmpty, Provider: "Gemini", FinishReason: "STOP", Parts: []string{"FunctionCall"}}, "no response from Gemini: only non-text parts (FunctionCall)"},
+		{&ResponseError{Kind: ErrEmpty, Provider: "Gemini", FinishReason: "OTHER"}, "no response from Gemini (finish reason OTHER)"},
+		{&ResponseError{Kind: ErrEmpty, Provider: "Gemini"}, "no response from Gemini"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+		if !errors.Is(tt.err, tt.err.Kind) {
+			t.Errorf("Expected errors.Is to match %v", tt.err.Kind)
+		}
+	}
+}
+
+func geminiTestClient(t *testing.T, response string) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test-api-key"), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatalf("Failed to create test genai client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &Client{model: client.GenerativeModel("gemini-pro")}
+}
+
+func TestClientGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		kind     error
+		expected ResponseError
+	}{
+		{
+			"prompt blocked",
+			`{"promptFeedback": {"blockReason": "SAFETY", "safetyRatings": [{"category": "HARM_CATEGORY_HARASSMENT", "probability": "HIGH"}]}}`,
+			ErrBlocked,
+			ResponseError{BlockReason: "SAFETY"},
+		},
+		{
+			"response blocked",
+			`{"candidates": [{"finishReason": "SAFETY", "safetyRatings": [{"category": "HARM_CATEGORY_HATE_SPEECH", "probability": "MEDIUM", "blocked": true}]}]}`,
+			ErrBlocked,
+			ResponseError{FinishReason: "SAFETY"},
+		},
+		{
+			"truncated",
+			`{"candidates": [{"content": {"parts": [], "role": "model"}, "finishReason": "MAX_TOKENS"}]}`,
+			ErrTruncated,
+			ResponseError{FinishReason: "MAX_TOKENS"},
+		},
+		{
+			"function call only",
+			`{"candidates": [{"content": {"parts": [{"functionCall": {"name": "ls", "args": {}}}], "role": "model"}, "finishReason": "STOP"}]}`,
+			ErrEmpty,
+			ResponseError{FinishReason: "STOP", Parts: []string{"FunctionCall"}},
+		},
+	}
+
+	for _, tt := range tests {
+		client := geminiTestClient(t, tt.response)
+		_, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""))
+
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) {
+			t.Errorf("%s: expected ResponseError, got %v", tt.name, err)
+			continue
+		}
+		if respErr.Kind != tt.kind || respErr.BlockReason != tt.expected.BlockReason || respErr.FinishReason != tt.expected.FinishReason {
+			t.Errorf("%s: unexpected error %+v", tt.name, respErr)
+		}
+		if len(respErr.Parts) != len(tt.expected.Parts) {
+			t.Errorf("%s: expected parts %q, got %q", tt.name, tt.expected.Parts, respErr.Parts)
+		}
+		if tt.kind == ErrBlocked && len(respErr.SafetyRatings) != 1 {
+			t.Errorf("%s: expected the safety ratings to be kept, got %+v", tt.name, respErr.SafetyRatings)
+		}
+	}
+}
+
+func TestVertexClientGenerateBlocked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates": [{"content": {"parts": [{"text": "partial"}], "role": "model"}, "finishReason": "PROHIBITED_CONTENT"}]}`))
+	}))
+	defer server.Close()
+
+	client := &VertexClient{httpClient: http.DefaultClient, url: server.URL}
+	_, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""))
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected ErrBlocked, got %v", err)
+	}
+}
+
+func TestOllamaClientGenerateTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "length"}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "llama3")
+	_, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""))
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errNoMatch = errors.New("nothing to extract")
+
+// langAliases maps common alternative fence languages to one name.
+var langAliases = map[string]string{
+	"golang":     "go",
+	"js":         "javascript",
+	"ts":         "typescript",
+	"py":         "python",
+	"sh":         "bash",
+	"shell":      "bash",
+	"zsh":        "bash",
+	"yml":        "yaml",
+	"c++":        "cpp",
+	"cc":         "cpp",
+	"dockerfile": "docker",
+}
+
+type codeBlock struct {
+	Lang string
+	Code string
+}
+
+// Extractor selects parts of a markdown response for --extract.
+type Extractor struct {
+	mode string // code, first-code or json
+	lang string
+}
+
+func ParseExtractor(spec string) (*Extractor, error) {
+	mode, lang, _ := strings.Cut(spec, ":")
+	switch mode {
+	case "code":
+		return &Extractor{mode: mode, lang: normalizeLang(lang)}, nil
+	case "first-code", "json":
+		if lang != "" {
+			return nil, fmt.Errorf("--extract=%s does not take a language", mode)
+		}
+		return &Extractor{mode: mode}, nil
+	}
+	return nil, fmt.Errorf("unknown extract mode %q (want code[:lang], first-code or json)", spec)
+}
+
+func (e *Extractor) Extract(text string) (string, error) {
+	if e.mode == "json" {
+		return extractJSON(text)
+	}
+
+	var matched []string
+	for _, b := range parseCodeBlocks(text) {
+		if e.lang != "" && normalizeLang(b.Lang) != e.lang {
+			continue
+		}
+		matched = append(matched, b.Code)
+		if e.mode == "first-code" {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		if e.lang != "" {
+			return "", fmt.Errorf("%w: no %s code blocks in response", errNoMatch, e.lang)
+		}
+		return "", fmt.Errorf("%w: no code blocks in response", errNoMatch)
+	}
+	return strings.Join(matched, "\n"), nil
+}
+
+// parseCodeBlocks returns the fenced code blocks of a markdown document.
+// An unclosed fence runs to the end of the document, as in CommonMark,
+// so truncated responses still yield their code.
+func parseCodeBlocks(text string) []codeBlock {
+	var (
+		blocks []codeBlock
+		fence  string
+		indent int
+		cur    *codeBlock
+		lines  []string
+	)
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		lead := len(line) - len(trimmed)
+
+		if cur == nil {
+			if lead > 3 {
+				continue
+			}
+			if f, info := openingFence(trimmed); f != "" {
+				lang, _, _ := strings.Cut(info, " ")
+				fence, indent, cur, lines = f, lead, &codeBlock{Lang: lang}, nil
+			}
+			continue
+		}
+
+		if lead <= 3 && strings.HasPrefix(trimmed, fence) && strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1])) == "" {
+			cur.Code = strings.Join(lines, "\n")
+			blocks = append(blocks, *cur)
+			cur = nil
+			continue
+		}
+
+		// Remove up to the fence's own indentation from content lines.
+		for i := 0; i < indent && strings.HasPrefix(line, " "); i++ {
+			line = line[1:]
+		}
+		lines = append(lines, line)
+	}
+
+	if cur != nil {
+		cur.Code = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+		blocks = append(blocks, *cur)
+	}
+	return blocks
+}
+
+// openingFence returns the fence that line, without its indentation,
+// opens and the info string after it. A backtick in the info string of a
+// backtick fence makes the line inline code, as in "```foo``` bar".
+func openingFence(line string) (fence, info string) {
+	fence = fenceOf(line)
+	if fence == "" {
+		return "", ""
+	}
+	info = strings.TrimSpace(line[len(fence):])
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return "", ""
+	}
+	return fence, info
+}
+
+func fenceOf(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return line[:n]
+		}
+	}
+	return ""
+}
+
+func normalizeLang(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if alias, ok := langAliases[lang]; ok {
+		return alias
+	}
+	return lang
+}
+
+// extractJSON returns the first JSON object or array in text, exactly as
+// it appears in the response.
+func extractJSON(text string) (string, error) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(text[i:]))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			continue
+		}
+		return string(bytes.TrimSpace(raw)), nil
+	}
+	return "", fmt.Errorf("%w: no valid JSON in response", errNoMatch)
+}
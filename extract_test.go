@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const markdownResponse = "Here is the fix:\n\n" +
+	"```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\n\n" +
+	"And a script:\n\n" +
+	"~~~sh\necho done\n~~~\n\n" +
+	"Inline ```not a fence``` here.\n\n" +
+	"  ````python extra info\n  print(1)\n  ```\n  print(2)\n  ````\n"
+
+func TestExtractCode(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"code", "func main() {\n\tfmt.Println(\"hi\")\n}\necho done\nprint(1)\n```\nprint(2)"},
+		{"code:go", "func main() {\n\tfmt.Println(\"hi\")\n}"},
+		{"code:golang", "func main() {\n\tfmt.Println(\"hi\")\n}"},
+		{"code:bash", "echo done"},
+		{"code:Python", "print(1)\n```\nprint(2)"},
+		{"first-code", "func main() {\n\tfmt.Println(\"hi\")\n}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			e, err := ParseExtractor(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseExtractor failed: %v", err)
+			}
+			got, err := e.Extract(markdownResponse)
+			if err != nil {
+				t.Fatalf("Extract failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Extract(%s) = %q, expected %q", tt.spec, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractUnclosedFence(t *testing.T) {
+	e, _ := ParseExtractor("code")
+	got, err := e.Extract("```yaml\nkey: value\n\n")
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if got != "key: value" {
+		t.Errorf("Expected truncated block content, got %q", got)
+	}
+}
+
+func TestExtractNoMatch(t *testing.T) {
+	for _, spec := range []string{"code", "code:rust", "first-code", "json"} {
+		e, _ := ParseExtractor(spec)
+		input := "No code or {json} here."
+		if spec == "code:rust" {
+			input = markdownResponse
+		}
+		if _, err := e.Extract(input); !errors.Is(err, errNoMatch) {
+			t.Errorf("Extract(%s) error = %v, expected errNoMatch", spec, err)
+		}
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"fenced", "Result:\n```json\n{\"ok\": true, \"items\": [1, 2]}\n```\n", `{"ok": true, "items": [1, 2]}`},
+		{"inline after invalid", "Use {braces} like [this]: {\"a\": {\"b\": \"}\"}} trailing", `{"a": {"b": "}"}}`},
+		{"array", "[1, 2, 3]", "[1, 2, 3]"},
+	}
+
+	e, _ := ParseExtractor("json")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.Extract(tt.input)
+			if err != nil {
+				t.Fatalf("Extract failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Extract() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseExtractorInvalid(t *testing.T) {
+	for _, spec := range []string{"html", "json:strict", "first-code:go", ""} {
+		if _, err := ParseExtractor(spec); err == nil {
+			t.Errorf("ParseExtractor(%q) expected error, got nil", spec)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"gopkg.in/yaml.v3"
+)
+
+// modelChain is a model name or a list of them, tried in order.
+type modelChain []string
+
+func (m *modelChain) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var name string
+		if err := value.Decode(&name); err != nil {
+			return err
+		}
+		*m = modelChain{name}
+		if name == "" {
+			*m = nil
+		}
+		return nil
+	}
+	var names []string
+	if err := value.Decode(&names); err != nil {
+		return fmt.Errorf("model must be a name or a list of names")
+	}
+	*m = names
+	return nil
+}
+
+// split returns the primary model and its fallbacks.
+func (m modelChain) split() (string, []string) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	return m[0], m[1:]
+}
+
+// The model key accepts a list, so these types decode it separately
+// into Model and Fallbacks.
+
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	type plain Config
+	var raw struct {
+		plain `yaml:",inline"`
+		Model modelChain `yaml:"model"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*c = Config(raw.plain)
+	c.Model, c.Fallbacks = raw.Model.split()
+	return nil
+}
+
+func (p *Prompt) UnmarshalYAML(value *yaml.Node) error {
+	type plain Prompt
+	var raw struct {
+		plain `yaml:",inline"`
+		Model modelChain `yaml:"model"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*p = Prompt(raw.plain)
+	p.Model, p.Fallbacks = raw.Model.split()
+	return nil
+}
+
+func (p *Profile) UnmarshalYAML(value *yaml.Node) error {
+	type plain Profile
+	var raw struct {
+		plain `yaml:",inline"`
+		Model modelChain `yaml:"model"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*p = Profile(raw.plain)
+	p.Model, p.Fallbacks = raw.Model.split()
+	return nil
+}
+
+// Models returns the prompt's model followed by its fallbacks.
+func (p *Prompt) Models() []string {
+	return append([]string{p.Model}, p.Fallbacks...)
+}
+
+// providerAliases are the provider prefixes accepted in model names.
+var providerAliases = map[string]string{
+	"gemini": "gemini",
+	"vertex": "vertex",
+	"ollama": "ollama",
+	"local":  "ollama",
+}
+
+// splitModel resolves a model name such as "local:llama3" to a provider
+// and model. Names without a known provider prefix use the configured
+// provider, so Ollama tags like "llama3:8b" keep working.
+func splitModel(cfg *Config, model string) (string, string) {
+	if prefix, name, ok := strings.Cut(model, ":"); ok {
+		if provider, ok := providerAliases[strings.ToLower(prefix)]; ok {
+			return provider, name
+		}
+	}
+	return providerName(cfg), model
+}
+
+// Attempt is a model that failed before another one answered.
+type Attempt struct {
+	Model string `json:"model"`
+	Error string `json:"error"`
+}
+
+// shouldFallback reports whether err is worth retrying with the next
+// model: quota or overload errors, timeouts and safety blocks.
+func shouldFallback(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, ErrBlocked) {
+		return true
+	}
+
+	status := 0
+	var apiErr *APIError
+	var googleErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.StatusCode
+	case errors.As(err, &googleErr):
+		status = googleErr.Code
+	}
+	switch status {
+	case 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
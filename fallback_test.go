@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestLoadConfigModelList(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, ".pipellm.yaml")
+	configContent := `model: [gemini-2.5-pro, gemini-2.5-flash, local:llama3]
+temperature: 0.2
+prompts:
+  - name: summary
+    prompt: Summarize
+  - name: review
+    model: gemini-2.5-pro
+    prompt: Review
+profiles:
+  cheap:
+    model:
+    - gemini-2.5-flash
+    - gemini-2.5-flash-lite
+`
+	os.WriteFile(configPath, []byte(configContent), 0o644)
+	t.Setenv("HOME", tempDir)
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if config.Model != "gemini-2.5-pro" || !reflect.DeepEqual(config.Fallbacks, []string{"gemini-2.5-flash", "local:llama3"}) {
+		t.Errorf("Unexpected model chain: %q %q", config.Model, config.Fallbacks)
+	}
+	if config.Params.Temperature == nil || *config.Params.Temperature != 0.2 {
+		t.Errorf("Expected inline params to still be parsed, got %+v", config.Params)
+	}
+
+	summary := config.ResolvePrompt("summary")
+	expected := []string{"gemini-2.5-pro", "gemini-2.5-flash", "local:llama3"}
+	if !reflect.DeepEqual(summary.Models(), expected) {
+		t.Errorf("Expected inherited chain %q, got %q", expected, summary.Models())
+	}
+	if review := config.ResolvePrompt("review"); !reflect.DeepEqual(review.Models(), []string{"gemini-2.5-pro"}) {
+		t.Errorf("Expected a single model for review, got %q", review.Models())
+	}
+
+	if err := config.ApplyProfile("cheap"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	expected = []string{"gemini-2.5-flash", "gemini-2.5-flash-lite"}
+	if summary := config.ResolvePrompt("summary"); !reflect.DeepEqual(summary.Models(), expected) {
+		t.Errorf("Expected profile chain %q, got %q", expected, summary.Models())
+	}
+}
+
+func TestSplitModel(t *testing.T) {
+	cfg := &Config{Provider: "gemini"}
+
+	tests := []struct {
+		input    string
+		provider string
+		model    string
+	}{
+		{"gemini-2.5-pro", "gemini", "gemini-2.5-pro"},
+		{"local:llama3", "ollama", "llama3"},
+		{"ollama:llama3:8b", "ollama", "llama3:8b"},
+		{"vertex:gemini-2.5-pro", "vertex", "gemini-2.5-pro"},
+		{"llama3:8b", "gemini", "llama3:8b"},
+	}
+	for _, tt := range tests {
+		provider, model := splitModel(cfg, tt.input)
+		if provider != tt.provider || model != tt.model {
+			t.Errorf("splitModel(%q): expected %s, %s, got %s, %s", tt.input, tt.provider, tt.model, provider, model)
+		}
+	}
+}
+
+func TestProviderConfig(t *testing.T) {
+	cfg := &Config{Provider: "gemini", Endpoint: "https://proxy", Endpoints: map[string]string{"ollama": "http://homebox:11434"}}
+
+	tests := []struct {
+		provider string
+		endpoint string
+	}{
+		{"gemini", "https://proxy"},
+		{"ollama", "http://homebox:11434"},
+		{"vertex", ""},
+	}
+	for _, tt := range tests {
+		if got := providerConfig(cfg, tt.provider); providerName(got) != tt.provider || got.Endpoint != tt.endpoint {
+			t.Errorf("providerConfig(%q): expected endpoint %q, got %s %q", tt.provider, tt.endpoint, got.Provider, got.Endpoint)
+		}
+	}
+
+	// endpoints also serves the configured provider without an endpoint.
+	cfg = &Config{Provider: "ollama", Endpoints: map[string]string{"ollama": "http://homebox:11434"}}
+	if got := providerConfig(cfg, "ollama"); got.Endpoint != "http://homebox:11434" {
+		t.Errorf("Expected the endpoints entry, got %q", got.Endpoint)
+	}
+}
+
+func TestShouldFallback(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&APIError{Provider: "vertex", StatusCode: 429, Message: "quota"}, true},
+		{fmt.Errorf("calling ollama API: %w", &APIError{Provider: "ollama", StatusCode: 503}), true},
+		{&googleapi.Error{Code: 503}, true},
+		{fmt.Errorf("calling gemini API: %w", context.DeadlineExceeded), true},
+		{&ResponseError{Kind: ErrBlocked, Provider: "Gemini", FinishReason: "SAFETY"}, true},
+		{&ResponseError{Kind: ErrTruncated, Provider: "Gemini"}, false},
+		{&APIError{Provider: "vertex", StatusCode: 403, Message: "Permission denied"}, false},
+		{&googleapi.Error{Code: 400}, false},
+		{fmt.Errorf("no response from Gemini"), false},
+	}
+	for _, tt := range tests {
+		if got := shouldFallback(tt.err); got != tt.expected {
+			t.Errorf("shouldFallback(%v): expected %v, got %v", tt.err, tt.expected, got)
+		}
+	}
+}
@@ -4,6 +4,8 @@ go 1.24.6
 
 require (
 	github.com/google/generative-ai-go v0.20.1
+	golang.org/x/oauth2 v0.21.0
+	golang.org/x/sys v0.28.0
 	google.golang.org/api v0.186.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -32,9 +34,7 @@ require (
 	go.opentelemetry.io/otel/trace v1.26.0 // indirect
 	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/net v0.26.0 // indirect
-	golang.org/x/oauth2 v0.21.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
-	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20240617180043-68d350f18fd4 // indirect
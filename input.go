@@ -6,9 +6,14 @@ import (
 	"strings"
 )
 
-func ReadStdin() string {
+// StdinIsTerminal reports whether stdin is a terminal rather than a pipe.
+func StdinIsTerminal() bool {
 	stat, _ := os.Stdin.Stat()
-	if (stat.Mode() & os.ModeCharDevice) != 0 {
+	return (stat.Mode() & os.ModeCharDevice) != 0
+}
+
+func ReadStdin() string {
+	if StdinIsTerminal() {
 		// Terminal mode - no piped input
 		return ""
 	}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func cmdInstallLinks(args []string, profile string) error {
+	home, _ := os.UserHomeDir()
+	fs := flag.NewFlagSet("install-links", flag.ContinueOnError)
+	dir := fs.String("dir", filepath.Join(home, ".local", "bin"), "Directory to create prompt links in")
+	remove := fs.Bool("remove", false, "Remove the prompt links install-links created in the directory")
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("getting executable path: %w", err)
+	}
+
+	var names []string
+	if !*remove {
+		cfg, err := loadConfig(profile)
+		if err != nil {
+			return err
+		}
+		names = shellCommands(cfg, execPath, os.Stderr)
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	recordPath, err := linkRecordPath()
+	if err != nil {
+		return err
+	}
+	record, err := loadLinkRecord(recordPath)
+	if err != nil {
+		return err
+	}
+	linked, err := installLinks(os.Stdout, abs, execPath, names, record[abs])
+	if len(linked) > 0 {
+		record[abs] = linked
+	} else {
+		delete(record, abs)
+	}
+	return errors.Join(err, saveLinkRecord(recordPath, record))
+}
+
+// installLinks makes dir contain exactly one symlink to execPath per name:
+// missing links are created and links to another pipellm binary are
+// updated. Of the links installed by an earlier run, those for names no
+// longer configured are removed; links made by hand are left alone, as
+// are files that are not pipellm links. It returns the names now linked.
+func installLinks(out io.Writer, dir, execPath string, names, installed []string) ([]string, error) {
+	var (
+		errs   []error
+		linked []string
+	)
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+
+		path := filepath.Join(dir, name)
+		target, err := os.Readlink(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if err := os.Symlink(execPath, path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Fprintf(out, "created %s\n", path)
+		case err != nil || !isPipellmLink(path, target, execPath):
+			errs = append(errs, fmt.Errorf("refusing to overwrite %s: not a pipellm link", path))
+			continue
+		case target != execPath:
+			if err := replaceLink(execPath, path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Fprintf(out, "updated %s\n", path)
+		}
+		linked = append(linked, name)
+	}
+
+	for _, name := range installed {
+		if wanted[name] || name == "pipellm" || name != filepath.Base(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		target, err := os.Readlink(path)
+		if err != nil || !isPipellmLink(path, target, execPath) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			linked = append(linked, name) // try again next time
+			continue
+		}
+		fmt.Fprintf(out, "removed %s\n", path)
+	}
+
+	sort.Strings(linked)
+	return linked, errors.Join(errs...)
+}
+
+// linkRecordPath returns $XDG_STATE_HOME/pipellm/links.json, which lists
+// the links install-links created in each directory.
+func linkRecordPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "links.json"), nil
+}
+
+// loadLinkRecord reads the link record. A missing file is an empty record.
+func loadLinkRecord(path string) (map[string][]string, error) {
+	record := make(map[string][]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return record, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return record, nil
+}
+
+func saveLinkRecord(path string, record map[string][]string) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// isPipellmLink reports whether the symlink at path points to this
+// binary or to another binary named pipellm (an older install).
+func isPipellmLink(path, target, execPath string) bool {
+	if strings.TrimSuffix(filepath.Base(target), ".exe") == "pipellm" {
+		return true
+	}
+	return sameFile(path, execPath)
+}
+
+// replaceLink atomically points the symlink at path to target.
+func replaceLink(target, path string) error {
+	tmp := path + ".pipellm-tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
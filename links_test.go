@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInstallLinks(t *testing.T) {
+	tempDir := t.TempDir()
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.Mkdir(binDir, 0o755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+
+	execPath := filepath.Join(tempDir, "new", "pipellm")
+	oldPath := filepath.Join(tempDir, "old", "pipellm")
+	for _, path := range []string{execPath, oldPath} {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte("binary"), 0o755); err != nil {
+			t.Fatalf("Failed to create fake binary: %v", err)
+		}
+	}
+
+	// An older install, a prompt that was deleted from the config, an
+	// alias made by hand, a foreign file and a foreign symlink.
+	os.Symlink(oldPath, filepath.Join(binDir, "review"))
+	os.Symlink(execPath, filepath.Join(binDir, "deleted"))
+	os.Symlink(execPath, filepath.Join(binDir, "ask"))
+	os.WriteFile(filepath.Join(binDir, "summary"), []byte("#!/bin/sh\n"), 0o755)
+	os.Symlink("/bin/true", filepath.Join(binDir, "other"))
+
+	var out bytes.Buffer
+	linked, err := installLinks(&out, binDir, execPath, []string{"kharms", "review", "summary"}, []string{"deleted", "review"})
+	if err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Errorf("Expected refusal to overwrite summary, got %v", err)
+	}
+
+	for _, want := range []string{"created " + filepath.Join(binDir, "kharms"), "updated " + filepath.Join(binDir, "review"), "removed " + filepath.Join(binDir, "deleted")} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	for _, name := range []string{"kharms", "review"} {
+		if target, err := os.Readlink(filepath.Join(binDir, name)); err != nil || target != execPath {
+			t.Errorf("Expected %s to link to %s, got %q (%v)", name, execPath, target, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(binDir, "deleted")); !os.IsNotExist(err) {
+		t.Errorf("Expected stale link to be removed, got %v", err)
+	}
+	if target, _ := os.Readlink(filepath.Join(binDir, "ask")); target != execPath {
+		t.Errorf("Alias made by hand was removed: %q", target)
+	}
+	if !reflect.DeepEqual(linked, []string{"kharms", "review"}) {
+		t.Errorf("Expected kharms and review to be linked, got %v", linked)
+	}
+	if data, _ := os.ReadFile(filepath.Join(binDir, "summary")); string(data) != "#!/bin/sh\n" {
+		t.Errorf("Foreign file was modified: %q", data)
+	}
+	if target, _ := os.Readlink(filepath.Join(binDir, "other")); target != "/bin/true" {
+		t.Errorf("Foreign symlink was modified: %q", target)
+	}
+
+	// A second run with fewer prompts only prunes.
+	out.Reset()
+	if _, err := installLinks(&out, binDir, execPath, []string{"review"}, linked); err != nil {
+		t.Fatalf("installLinks failed: %v", err)
+	}
+	if out.String() != "removed "+filepath.Join(binDir, "kharms")+"\n" {
+		t.Errorf("Unexpected output on second run:\n%s", out.String())
+	}
+}
+
+func TestLinkRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "links.json")
+	record, err := loadLinkRecord(path)
+	if err != nil || len(record) != 0 {
+		t.Fatalf("Expected an empty record, got %v (%v)", record, err)
+	}
+
+	record["/home/u/bin"] = []string{"kharms", "review"}
+	if err := saveLinkRecord(path, record); err != nil {
+		t.Fatalf("saveLinkRecord failed: %v", err)
+	}
+	loaded, err := loadLinkRecord(path)
+	if err != nil {
+		t.Fatalf("loadLinkRecord failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, record) {
+		t.Errorf("Expected %v, got %v", record, loaded)
+	}
+}
@@ -1,79 +1,581 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+const usage = `Usage:
+  pipellm [--profile name] <command> [args]
+  pipellm [--profile name] <prompt> [flags]
+  <prompt> [flags]              (via alias or symlink)
+
+Commands:
+  list                          List prompts with descriptions
+  show <name>                   Show the resolved prompt, model and params
+  run <name> [flags]            Run a prompt on stdin, or chat without piped input
+  config path|edit|validate     Manage ~/.pipellm.yaml
+  install-links [--dir dir]     Symlink prompt commands into dir (default ~/.local/bin)
+  cache stats|clear             Inspect or empty the response cache
+  usage [--since 7d] [--by prompt|model]
+                                Report token usage and cost
+  mcp serve                     Offer the prompts as tools to MCP clients on stdio
+  serve [--listen addr]         Serve the prompts over HTTP (default localhost:8080)
+  daemon [status|stop]          Keep API clients warm for faster runs
+
+Flags:
+`
+
+var subcommands = []string{"list", "show", "run", "config", "install-links", "cache", "usage", "mcp", "serve", "daemon", "help"}
+
+func isSubcommand(name string) bool {
+	for _, cmd := range subcommands {
+		if cmd == name {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
-	bashAlias := flag.Bool("bash-alias", false, "Generate bash aliases for all prompts")
+	if len(os.Args) == 3 && os.Args[1] == sandboxHelperArg {
+		runSandboxHelper(os.Args[2])
+	}
+
+	// Busybox-style dispatch: a symlink or copy named after a prompt runs it.
+	if name := progName(); isPromptLink(name, os.Getenv("PIPELLM_PROFILE")) {
+		exit(runPrompt(name, os.Args[1:], os.Getenv("PIPELLM_PROFILE")))
+	}
+
+	bashAlias := flag.Bool("bash-alias", false, "Generate bash aliases for all prompts (same as --shell=bash)")
+	shell := flag.String("shell", "", "Print aliases and completions for `shell`: bash, zsh, fish or pwsh")
+	profile := flag.String("profile", os.Getenv("PIPELLM_PROFILE"), "Config profile to use (default $PIPELLM_PROFILE)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if *bashAlias {
-		generateAliases()
-		return
+		*shell = "bash"
+	}
+	if *shell != "" {
+		exit(generateShell(*shell, *profile))
 	}
 
-	var promptName string
-	if flag.NArg() > 0 {
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "list":
+		exit(cmdList(args, *profile))
+	case "show":
+		exit(cmdShow(args, *profile))
+	case "run":
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			exit(fmt.Errorf("usage: pipellm run <name> [flags]"))
+		}
+		exit(runPrompt(args[0], args[1:], *profile))
+	case "config":
+		exit(cmdConfig(args, *profile))
+	case "install-links":
+		exit(cmdInstallLinks(args, *profile))
+	case "cache":
+		exit(cmdCache(args, *profile))
+	case "usage":
+		exit(cmdUsage(args, *profile))
+	case "mcp":
+		exit(cmdMCP(args, *profile))
+	case "serve":
+		exit(cmdServe(args, *profile))
+	case "daemon":
+		exit(cmdDaemon(args, *profile))
+	case "help":
+		flag.Usage()
+	default:
 		// Called with alias name as argument
-		promptName = flag.Arg(0)
-	} else {
-		// Called directly by binary name
-		promptName = filepath.Base(os.Args[0])
+		exit(runPrompt(cmd, args, *profile))
+	}
+}
+
+func progName() string {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+}
+
+// isPromptLink reports whether a binary called name runs the prompt of
+// that name. Any other name, such as that of a renamed copy of pipellm,
+// takes the usual subcommands and arguments.
+func isPromptLink(name, profile string) bool {
+	if name == "pipellm" {
+		return false
+	}
+	cfg, err := loadConfig(profile)
+	return err == nil && cfg.ResolvePrompt(name) != nil
+}
+
+// Exit codes other than 0 (success), 1 (error) and 2 (usage).
+const (
+	exitBudget    = 3
+	exitBlocked   = 4
+	exitTruncated = 5
+	exitEmpty     = 6
+)
+
+func exit(err error) {
+	if err == nil {
+		os.Exit(0)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 
-	cfg, err := LoadConfig()
+	var budgetErr *BudgetError
+	switch {
+	case errors.As(err, &budgetErr):
+		os.Exit(exitBudget)
+	case errors.Is(err, ErrBlocked):
+		os.Exit(exitBlocked)
+	case errors.Is(err, ErrTruncated):
+		os.Exit(exitTruncated)
+	case errors.Is(err, ErrEmpty):
+		os.Exit(exitEmpty)
+	}
+	os.Exit(1)
+}
+
+// runOptions are the flags accepted when running a prompt.
+type runOptions struct {
+	profile      string
+	model        string
+	dryRun       bool
+	printRequest bool
+	output       string
+	extract      string
+	render       string
+	files        fileList
+	patch        bool
+	apply        bool
+	backup       bool
+	noChat       bool
+	session      string
+	noCache      bool
+	refresh      bool
+	ignoreBudget bool
+	verbose      bool
+	continuation continueFlag
+	confirmTools bool
+	vars         varFlag
+
+	// onText, if set, receives the answer text as it is generated.
+	onText func(string)
+}
+
+func (o *runOptions) register(fs *flag.FlagSet, profile string) {
+	fs.StringVar(&o.profile, "profile", profile, "Config profile to use")
+	fs.StringVar(&o.model, "model", "", "Override the model for this call")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Print what would be sent without calling the API")
+	fs.BoolVar(&o.printRequest, "print-request", false, "Print the provider-native request JSON without calling the API")
+	fs.StringVar(&o.output, "output", "text", "Output `format`: text or json")
+	fs.StringVar(&o.extract, "extract", "", "Output only `code[:lang]`, first-code or json from the response")
+	fs.StringVar(&o.render, "render", "auto", "Render markdown for the terminal: auto, always or never")
+	fs.Var(&o.files, "file", "Attach a file to the prompt (repeatable)")
+	fs.BoolVar(&o.patch, "patch", false, "Ask for a unified diff against the attached files and validate it")
+	fs.BoolVar(&o.apply, "apply", false, "Apply the validated --patch to the files")
+	fs.BoolVar(&o.backup, "backup", false, "Save the originals as .orig files when applying")
+	fs.BoolVar(&o.noChat, "no-chat", false, "Send the prompt once instead of chatting when stdin is a terminal")
+	fs.StringVar(&o.session, "session", "", "Continue the conversation stored under `name`")
+	fs.BoolVar(&o.noCache, "no-cache", false, "Neither read nor write the response cache")
+	fs.BoolVar(&o.refresh, "refresh", false, "Ignore cached responses but cache the new one")
+	fs.BoolVar(&o.ignoreBudget, "ignore-budget", false, "Run even if the call would exceed a budget")
+	fs.BoolVar(&o.verbose, "verbose", false, "Report fallbacks and which model answered on stderr")
+	fs.BoolVar(&o.confirmTools, "confirm-tools", false, "Ask on the terminal before running each tool call")
+	fs.Var(&o.vars, "var", "Set a prompt variable as `name=value` (repeatable)")
+	fs.Var(&o.continuation, "continue-on-truncate", "Ask for the rest of a truncated response, up to `n` times (default 3 when given alone)")
+}
+
+func runPrompt(name string, args []string, profile string) error {
+	var opts runOptions
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	opts.register(fs, profile)
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(rest, " "))
+	}
+	if opts.output != "text" && opts.output != "json" {
+		return fmt.Errorf("unknown output format %q (want text or json)", opts.output)
+	}
+	if opts.patch && len(opts.files) == 0 {
+		return fmt.Errorf("--patch needs at least one --file")
+	}
+	if (opts.apply || opts.backup) && !opts.patch {
+		return fmt.Errorf("--apply and --backup need --patch")
+	}
+	render, err := shouldRender(opts.render)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	var extractor *Extractor
+	if opts.extract != "" {
+		if extractor, err = ParseExtractor(opts.extract); err != nil {
+			return err
+		}
 	}
 
-	prompt := cfg.FindPrompt(promptName)
-	if prompt == "" {
-		fmt.Fprintf(os.Stderr, "No prompt found for name: %s\n", promptName)
-		os.Exit(1)
+	cfg, err := loadConfig(opts.profile)
+	if err != nil {
+		return err
+	}
+
+	prompt := cfg.ResolvePrompt(name)
+	if prompt == nil {
+		return fmt.Errorf("no prompt found for name: %s", name)
+	}
+
+	var (
+		session     *Transcript
+		sessionFile string
+	)
+	if opts.session != "" {
+		if sessionFile, err = sessionPath(opts.session); err != nil {
+			return err
+		}
+		if session, err = loadSession(sessionFile); err != nil {
+			return fmt.Errorf("loading session: %w", err)
+		}
+		if session.Model != "" {
+			prompt.Model = session.Model
+		}
+	}
+	if opts.model != "" {
+		prompt.Model, prompt.Fallbacks = opts.model, nil
+	}
+	if prompt, err = prompt.Expand(opts.vars); err != nil {
+		return err
+	}
+
+	// Without piped input a plain text run becomes an interactive chat.
+	interactive := !opts.noChat && !opts.dryRun && !opts.printRequest && !opts.patch &&
+		extractor == nil && opts.output == "text" && len(opts.files) == 0
+	if interactive && StdinIsTerminal() {
+		return runChat(cfg, prompt, render, session, sessionFile, &opts)
 	}
 
 	userInput := ReadStdin()
+	req := NewRequest(prompt, userInput)
+	if session != nil && len(session.History) > 0 {
+		// The prompt text was sent with the first turn of the session.
+		req = NewRequest(&Prompt{System: prompt.System, Params: prompt.Params, Safety: prompt.Safety}, userInput)
+		req.History = session.History
+	}
+	if err := attachFiles(req, opts.files); err != nil {
+		return err
+	}
+	if opts.patch {
+		req.Parts = append(req.Parts, patchInstruction)
+	}
 
-	modelName := cfg.Model
-	if modelName == "" {
-		modelName = "gemini-pro" // default model
+	provider, model := splitModel(cfg, prompt.Model)
+	if opts.dryRun || opts.printRequest {
+		// A dry run has no side effects, so MCP servers are not started.
+		req.Tools = declaredTools(cfg, prompt)
+		if len(prompt.MCP) > 0 {
+			fmt.Fprintf(os.Stderr, "Note: tools of mcp servers %s are not listed, as they are not started\n", strings.Join(prompt.MCP, ", "))
+		}
+		if opts.dryRun {
+			writeDryRun(os.Stdout, provider, model, req)
+			return nil
+		}
+		data, err := MarshalRequest(provider, model, req)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
 	}
 
-	client, err := NewClient(cfg.APIKey, modelName)
+	tools, err := newToolRunner(context.Background(), cfg, prompt, opts.confirmTools)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating client: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	if tools != nil {
+		defer tools.Close()
+		req.Tools = tools.specs
+		if opts.verbose {
+			tools.log = os.Stderr
+		}
+	}
+
+	start := time.Now()
+	req, res, err := answer(context.Background(), cfg, prompt, req, tools, &opts)
+	var respErr *ResponseError
+	if opts.output == "json" && errors.As(err, &respErr) {
+		// Scripts get the finish and block reasons along with the exit code.
+		if err := writeOutput(os.Stdout, opts.output, NewErrorEnvelope(err, respErr, time.Since(start))); err != nil {
+			return err
+		}
 	}
-	response, err := client.SendPrompt(prompt, userInput)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error calling Gemini API: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	if opts.verbose {
+		fmt.Fprintf(os.Stderr, "answered by %s:%s\n", res.provider, res.model)
+	}
+	if len(res.resp.OtherParts) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: ignored non-text parts in response: %s\n", strings.Join(res.resp.OtherParts, ", "))
+	}
+	if session != nil {
+		session.Record(prompt, req, res.resp)
+		if err := session.Save(sessionFile); err != nil {
+			return fmt.Errorf("saving session: %w", err)
+		}
+	}
+
+	env := NewEnvelope(res.provider, res.model, res.resp, time.Since(start))
+	env.Cached = res.cached
+	env.Fallbacks = res.failed
+
+	// Truncated answers are still printed, but reported by the exit code.
+	var truncated error
+	if res.resp.FinishReason == "MAX_TOKENS" {
+		truncated = &ResponseError{Kind: ErrTruncated, Provider: providerTitle(res.provider), FinishReason: res.resp.FinishReason}
+	}
+
+	switch {
+	case opts.patch:
+		if truncated != nil {
+			// Never apply half a diff.
+			return truncated
+		}
+		return showPatch(env, &opts, render)
+	case extractor != nil:
+		if env.Text, err = extractor.Extract(env.Text); err != nil {
+			return err
+		}
+	case render && opts.output == "text":
+		env.Text = RenderMarkdown(env.Text, terminalWidth())
+	}
+	if err := writeOutput(os.Stdout, opts.output, env); err != nil {
+		return err
 	}
+	return truncated
+}
+
+// result is the outcome of generate.
+type result struct {
+	resp     *Response
+	provider string
+	model    string
+	cached   bool
+	failed   []Attempt // models that failed before this one answered
+}
+
+// modelError is the error of the last model generate tried.
+type modelError struct {
+	provider string
+	model    string
+	failed   []Attempt
+	err      error
+}
+
+func (e *modelError) Error() string { return e.err.Error() }
+
+func (e *modelError) Unwrap() error { return e.err }
 
-	fmt.Println(response)
+// pin returns a copy of prompt that only uses the model that answered,
+// for follow-up turns.
+func (r *result) pin(prompt *Prompt) *Prompt {
+	pinned := *prompt
+	pinned.Model, pinned.Fallbacks = r.provider+":"+r.model, nil
+	return &pinned
 }
 
-func generateAliases() {
-	cfg, err := LoadConfig()
+// generate tries the prompt's models in order until one answers. Each
+// model's response comes from the cache when allowed; fresh responses
+// are recorded in the usage ledger and cached. Quota, overload, timeout
+// and safety errors fall through to the next model.
+func generate(ctx context.Context, cfg *Config, prompt *Prompt, req *Request, opts *runOptions) (*result, error) {
+	cache, err := OpenCache(cfg.Cache)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
+	timeout, err := cfg.requestTimeout()
+	if err != nil {
+		return nil, err
+	}
+	useCache := !opts.noCache && cache.Cacheable(req)
+
+	var failed []Attempt
+	models := prompt.Models()
+	for i, m := range models {
+		provider, model := splitModel(cfg, m)
+		key := CacheKey(providerConfig(cfg, provider), model, req)
+		if useCache && !opts.refresh {
+			if resp, ok := cache.Get(key); ok {
+				if opts.onText != nil {
+					opts.onText(resp.Text)
+				}
+				return &result{resp, provider, model, true, failed}, nil
+			}
+		}
 
-	execPath, err := os.Executable()
+		warnUnsupportedSafety(provider, req.Safety)
+		if !opts.ignoreBudget {
+			priced := *prompt
+			priced.Model = model
+			if err := checkBudget(cfg, &priced, req); err != nil {
+				return nil, err
+			}
+		}
+
+		client, err := connectProvider(cfg, m)
+		if err != nil {
+			return nil, fmt.Errorf("creating client: %w", err)
+		}
+		var onText func(string)
+		streamed := false
+		if opts.onText != nil {
+			onText = func(text string) {
+				streamed = true
+				opts.onText(text)
+			}
+		}
+		resp, err := generateWithTimeout(ctx, client, req, timeout, onText)
+		if err != nil {
+			err = fmt.Errorf("calling %s API: %w", provider, err)
+			// Once the caller gives up, the next model would fail too,
+			// and text already passed on cannot be taken back.
+			if !shouldFallback(err) || i == len(models)-1 || ctx.Err() != nil || streamed {
+				if len(failed) > 0 {
+					err = fmt.Errorf("all %d models failed, last: %w", len(failed)+1, err)
+				}
+				return nil, &modelError{provider, model, failed, err}
+			}
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "%s failed, falling back: %v\n", m, err)
+			}
+			failed = append(failed, Attempt{Model: m, Error: err.Error()})
+			continue
+		}
+		recordUsage(prompt.Name, provider, model, resp)
+
+		if useCache {
+			// A broken cache should not fail an otherwise successful call.
+			if err := cache.Put(key, resp); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: caching response: %v\n", err)
+			}
+		}
+		return &result{resp, provider, model, false, failed}, nil
+	}
+	return nil, fmt.Errorf("no model configured")
+}
+
+// answer sends req and follows up on the response: it runs the tool
+// calls the model makes and continues a truncated answer. It returns the
+// last request sent along with the result.
+func answer(ctx context.Context, cfg *Config, prompt *Prompt, req *Request, tools *toolRunner, opts *runOptions) (*Request, *result, error) {
+	res, err := generate(ctx, cfg, prompt, req, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting executable path: %v\n", err)
-		os.Exit(1)
+		return nil, nil, err
+	}
+	if tools != nil {
+		pinned := res.pin(prompt)
+		send := func(r *Request) (*Response, error) {
+			next, err := generate(ctx, cfg, pinned, r, opts)
+			if err != nil {
+				return nil, err
+			}
+			return next.resp, nil
+		}
+		if req, res.resp, err = tools.runTools(ctx, req, res.resp, send); err != nil {
+			return nil, nil, err
+		}
+	}
+	if limit := opts.continuation.limit(prompt); limit > 0 {
+		if res, err = continueTruncated(ctx, cfg, prompt, req, res, limit, opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	return req, res, nil
+}
+
+// generateWithTimeout calls client, streaming the text to onText if it
+// is not nil. Providers that cannot stream pass it on in one piece.
+func generateWithTimeout(ctx context.Context, client Provider, req *Request, timeout time.Duration, onText func(string)) (*Response, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
+	if onText == nil {
+		return client.Generate(ctx, req)
+	}
+	if streamer, ok := client.(Streamer); ok {
+		return streamer.GenerateStream(ctx, req, onText)
+	}
+	resp, err := client.Generate(ctx, req)
+	if err == nil && resp.Text != "" {
+		onText(resp.Text)
+	}
+	return resp, err
+}
+
+func showPatch(env *Envelope, opts *runOptions, render bool) error {
+	results, err := PreparePatch(env.Text, opts.files)
+	if err != nil {
+		return fmt.Errorf("invalid patch: %w", err)
+	}
+
+	env.Text = extractDiff(env.Text)
+	if render && opts.output == "text" {
+		env.Text = colorDiff(env.Text)
+	}
+	if err := writeOutput(os.Stdout, opts.output, env); err != nil {
+		return err
+	}
+
+	if opts.apply {
+		return ApplyPatch(os.Stderr, results, opts.backup)
+	}
+	return nil
+}
+
+func loadConfig(profile string) (*Config, error) {
+	cfg, err := connectConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	if err := cfg.ApplyProfile(profile); err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	return cfg, nil
+}
 
-	for _, prompt := range cfg.Prompts {
-		alias := strings.ToLower(prompt.Name)
-		fmt.Printf("alias %s='%s %s'\n", alias, execPath, alias)
+// parseArgs parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
 }
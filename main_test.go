@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Sandboxed tool commands re-execute the test binary.
+	if len(os.Args) == 3 && os.Args[1] == sandboxHelperArg {
+		runSandboxHelper(os.Args[2])
+	}
+	// MCP client tests start the test binary as their server.
+	if mode := os.Getenv("PIPELLM_TEST_MCP_SERVER"); mode != "" {
+		os.Exit(serveTestMCP(mode))
+	}
+	// Model calls must not reach a daemon the developer has running;
+	// the daemon tests clear this.
+	os.Setenv("PIPELLM_NO_DAEMON", "1")
+	os.Exit(m.Run())
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		profile    string
+		model      string
+		positional []string
+	}{
+		{name: "no args", args: nil},
+		{name: "flags only", args: []string{"--profile", "work", "--model=m"}, profile: "work", model: "m"},
+		{name: "name first", args: []string{"review", "--model", "m"}, model: "m", positional: []string{"review"}},
+		{name: "interspersed", args: []string{"-profile=p", "review", "extra", "-model", "m"}, profile: "p", model: "m", positional: []string{"review", "extra"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts runOptions
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			opts.register(fs, "")
+
+			positional, err := parseArgs(fs, tt.args)
+			if err != nil {
+				t.Fatalf("parseArgs failed: %v", err)
+			}
+			if opts.profile != tt.profile || opts.model != tt.model {
+				t.Errorf("Expected profile=%q model=%q, got profile=%q model=%q", tt.profile, tt.model, opts.profile, opts.model)
+			}
+			if !reflect.DeepEqual(positional, tt.positional) {
+				t.Errorf("Expected positional %v, got %v", tt.positional, positional)
+			}
+		})
+	}
+}
+
+func TestParseArgsUnknownFlag(t *testing.T) {
+	var opts runOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts.register(fs, "")
+
+	if _, err := parseArgs(fs, []string{"--bogus"}); err == nil {
+		t.Fatal("Expected error for unknown flag, got nil")
+	}
+}
+
+func TestGenerateFallback(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Model {
+		case "big":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "server busy"}`))
+		case "broken":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "bad request"}`))
+		default:
+			w.Write([]byte(`{"model": "small", "message": {"role": "assistant", "content": "hi"}, "done_reason": "stop"}`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := &Config{Provider: "ollama", Endpoint: server.URL}
+	prompt := &Prompt{Name: "summary", Model: "big", Fallbacks: []string{"small", "unused"}}
+
+	res, err := generate(context.Background(), cfg, prompt, &Request{Parts: []string{"hello"}}, &runOptions{})
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	if res.model != "small" || res.resp.Text != "hi" {
+		t.Errorf("Expected answer from small, got %s: %q", res.model, res.resp.Text)
+	}
+	if len(res.failed) != 1 || res.failed[0].Model != "big" || !strings.Contains(res.failed[0].Error, "server busy") {
+		t.Errorf("Unexpected failed attempts: %+v", res.failed)
+	}
+	if !reflect.DeepEqual(models, []string{"big", "small"}) {
+		t.Errorf("Expected big then small to be called, got %q", models)
+	}
+
+	// Errors that another model would not fix are returned at once.
+	models = nil
+	prompt = &Prompt{Name: "summary", Model: "broken", Fallbacks: []string{"small"}}
+	if _, err := generate(context.Background(), cfg, prompt, &Request{Parts: []string{"hello"}}, &runOptions{}); err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Errorf("Expected bad request error, got %v", err)
+	}
+	if !reflect.DeepEqual(models, []string{"broken"}) {
+		t.Errorf("Expected no fallback after a bad request, got %q", models)
+	}
+
+	prompt = &Prompt{Name: "summary", Model: "big", Fallbacks: []string{"big"}}
+	if _, err := generate(context.Background(), cfg, prompt, &Request{Parts: []string{"hello"}}, &runOptions{}); err == nil || !strings.Contains(err.Error(), "all 2 models failed") {
+		t.Errorf("Expected all models to fail, got %v", err)
+	}
+}
+
+func TestIsPromptLink(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if isPromptLink("review", "") {
+		t.Error("Expected no prompt link without a config")
+	}
+	os.WriteFile(filepath.Join(home, ".pipellm.yaml"), []byte("prompts:\n- name: review\n  prompt: Review this\n"), 0o644)
+	for name, expected := range map[string]bool{"review": true, "Review": true, "pipellm": false, "pipellm-dev": false} {
+		if got := isPromptLink(name, ""); got != expected {
+			t.Errorf("isPromptLink(%q): expected %v, got %v", name, expected, got)
+		}
+	}
+}
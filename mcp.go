@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MCPServer is a Model Context Protocol server that is started as a
+// local process and spoken to over stdin and stdout.
+type MCPServer struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`     // added to the sandbox environment
+	Confirm bool              `yaml:"confirm"` // ask before every call
+}
+
+const (
+	mcpProtocolVersion = "2025-06-18"
+	mcpStartTimeout    = 30 * time.Second
+	mcpStopTimeout     = 2 * time.Second
+	mcpMaxMessage      = 16 << 20
+)
+
+// mcpProtocolVersions are the versions we can talk; the tools methods
+// are the same in all of them.
+var mcpProtocolVersions = map[string]bool{"2025-06-18": true, "2025-03-26": true, "2024-11-05": true}
+
+var mcpServerNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+func (s *MCPServer) validate(name string) error {
+	if !mcpServerNameRe.MatchString(name) {
+		return fmt.Errorf("invalid mcp server name %q (use letters, digits and '-')", name)
+	}
+	if strings.TrimSpace(s.Command) == "" {
+		return fmt.Errorf("mcp server %q has no command", name)
+	}
+	return nil
+}
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+const rpcMethodNotFound = -32601
+
+// mcpTool is a tool offered by an MCP server.
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+// mcpContent is one item of a tool result.
+type mcpContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	MimeType string `json:"mimeType,omitempty"`
+	Resource *struct {
+		URI  string `json:"uri"`
+		Text string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+type mcpCallResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// text joins the text content of r. Other content is only mentioned,
+// as tool results are text.
+func (r *mcpCallResult) text() string {
+	var parts []string
+	for _, c := range r.Content {
+		switch {
+		case c.Type == "text":
+			parts = append(parts, c.Text)
+		case c.Type == "resource" && c.Resource != nil && c.Resource.Text != "":
+			parts = append(parts, c.Resource.Text)
+		case c.MimeType != "":
+			parts = append(parts, fmt.Sprintf("[%s content omitted]", c.MimeType))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content omitted]", c.Type))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// mcpClient is a running MCP server. Calls are made one at a time.
+type mcpClient struct {
+	name     string
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stderr   *limitedBuffer
+	incoming chan rpcMessage
+	done     chan struct{} // closed when the server has exited
+	waitErr  error
+
+	mu     sync.Mutex
+	nextID int
+}
+
+// startMCP starts the server and performs the protocol handshake. The
+// server gets env plus its own variables.
+func startMCP(ctx context.Context, name string, s MCPServer, sandbox *Sandbox) (*mcpClient, error) {
+	cmd, err := sandbox.serverCommand(s.Command, s.Args)
+	if err != nil {
+		return nil, fmt.Errorf("starting mcp server %s: %w", name, err)
+	}
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+s.Env[k])
+	}
+	c := &mcpClient{
+		name:     name,
+		cmd:      cmd,
+		stderr:   &limitedBuffer{max: maxToolStderr},
+		incoming: make(chan rpcMessage, 16),
+		done:     make(chan struct{}),
+	}
+	cmd.Stderr = c.stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting mcp server %s: %w", name, err)
+	}
+	c.stdin = stdin
+	go c.read(stdout)
+
+	ctx, cancel := context.WithTimeout(ctx, mcpStartTimeout)
+	defer cancel()
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	err = c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "pipellm", "version": "1"},
+	}, &init)
+	if err == nil && !mcpProtocolVersions[init.ProtocolVersion] {
+		err = fmt.Errorf("unsupported protocol version %q", init.ProtocolVersion)
+	}
+	if err == nil {
+		err = c.notify("notifications/initialized", nil)
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("mcp server %s: %w", name, err)
+	}
+	return c, nil
+}
+
+// read passes the server's messages to incoming until it exits.
+func (c *mcpClient) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), mcpMaxMessage)
+	for scanner.Scan() {
+		var msg rpcMessage
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		c.incoming <- msg
+	}
+	// Drain stdout so that the server does not block before exiting.
+	io.Copy(io.Discard, stdout)
+	close(c.incoming)
+	c.waitErr = c.cmd.Wait()
+	close(c.done)
+}
+
+func (c *mcpClient) write(msg rpcMessage) error {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (c *mcpClient) notify(method string, params any) error {
+	msg := rpcMessage{Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+	return c.write(msg)
+}
+
+// call sends a request and waits for its response, decoding the result
+// into result if it is not nil.
+func (c *mcpClient) call(ctx context.Context, method string, params, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	if err := c.write(rpcMessage{ID: json.RawMessage(id), Method: method, Params: data}); err != nil {
+		return c.exitError(err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-c.incoming:
+			if !ok {
+				return c.exitError(nil)
+			}
+			if msg.Method != "" {
+				c.answer(msg)
+				continue
+			}
+			if string(msg.ID) != id {
+				continue
+			}
+			if msg.Error != nil {
+				return msg.Error
+			}
+			if result == nil {
+				return nil
+			}
+			return json.Unmarshal(msg.Result, result)
+		}
+	}
+}
+
+// answer replies to a request from the server. We offer no client
+// features, so only pings succeed.
+func (c *mcpClient) answer(req rpcMessage) {
+	if req.ID == nil {
+		return
+	}
+	resp := rpcMessage{ID: req.ID}
+	if req.Method == "ping" {
+		resp.Result = json.RawMessage("{}")
+	} else {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	c.write(resp)
+}
+
+// exitError explains why the server stopped answering.
+func (c *mcpClient) exitError(err error) error {
+	select {
+	case <-c.done:
+	case <-time.After(mcpStopTimeout):
+		if err == nil {
+			err = errors.New("server closed its output")
+		}
+		return err
+	}
+	err = c.waitErr
+	if err == nil {
+		err = errors.New("server exited")
+	}
+	if msg := strings.TrimSpace(c.stderr.buf.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// ListTools returns all tools the server offers.
+func (c *mcpClient) ListTools(ctx context.Context) ([]mcpTool, error) {
+	var tools []mcpTool
+	params := map[string]any{}
+	for {
+		var page struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, fmt.Errorf("listing tools of mcp server %s: %w", c.name, err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		params = map[string]any{"cursor": page.NextCursor}
+	}
+}
+
+// CallTool calls the named tool with args, a JSON object. A result the
+// server marks as an error is returned as one.
+func (c *mcpClient) CallTool(ctx context.Context, name string, args []byte) (string, error) {
+	var result mcpCallResult
+	params := map[string]any{"name": name, "arguments": json.RawMessage(args)}
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return "", err
+	}
+	if result.IsError {
+		return "", errors.New(result.text())
+	}
+	return result.text(), nil
+}
+
+// Close stops the server, killing it if it does not exit on its own.
+func (c *mcpClient) Close() error {
+	c.stdin.Close()
+	go func() {
+		for range c.incoming {
+		}
+	}()
+	select {
+	case <-c.done:
+	case <-time.After(mcpStopTimeout):
+		c.cmd.Process.Kill()
+		<-c.done
+	}
+	return nil
+}
+
+var mcpToolNameRe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// mcpToolName returns the name the model sees for a server's tool. The
+// server name keeps tools of different servers apart.
+func mcpToolName(server, tool string) string {
+	name := server + "__" + mcpToolNameRe.ReplaceAllString(tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// mcpSchema converts a tool's JSON schema to the subset models accept.
+func mcpSchema(raw json.RawMessage) (*Schema, error) {
+	var v map[string]any
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("invalid input schema: %w", err)
+		}
+	}
+	s := schemaFromJSON(v)
+	s.Type = "object"
+	if len(s.Properties) == 0 {
+		// Models reject objects without properties.
+		return nil, nil
+	}
+	return s, nil
+}
+
+func schemaFromJSON(v map[string]any) *Schema {
+	s := &Schema{}
+	s.Description, _ = v["description"].(string)
+	switch t := v["type"].(type) {
+	case string:
+		s.Type = t
+	case []any:
+		for _, item := range t {
+			if name, _ := item.(string); name == "null" {
+				s.Nullable = true
+			} else if s.Type == "" {
+				s.Type = name
+			}
+		}
+	}
+	if enum, ok := v["enum"].([]any); ok {
+		for _, e := range enum {
+			if str, ok := e.(string); ok {
+				s.Enum = append(s.Enum, str)
+			}
+		}
+	}
+	if items, ok := v["items"].(map[string]any); ok {
+		s.Items = schemaFromJSON(items)
+	}
+	if props, ok := v["properties"].(map[string]any); ok {
+		s.Properties = make(map[string]*Schema)
+		for name, p := range props {
+			p, _ := p.(map[string]any)
+			s.Properties[name] = schemaFromJSON(p)
+		}
+	}
+	if required, ok := v["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				s.Required = append(s.Required, name)
+			}
+		}
+	}
+
+	if !schemaTypes[s.Type] {
+		switch {
+		case s.Properties != nil:
+			s.Type = "object"
+		case s.Items != nil:
+			s.Type = "array"
+		default:
+			s.Type = "string"
+		}
+	}
+	if s.Type == "array" && s.Items == nil {
+		s.Items = &Schema{Type: "string"}
+	}
+	return s
+}
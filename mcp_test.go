@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveTestMCP is a tiny MCP server with tools echo, fail and env. The
+// tool list comes in two pages and the server pings the client before
+// answering a call. With mode "crash" it exits right away.
+func serveTestMCP(mode string) int {
+	if mode == "crash" {
+		fmt.Fprintln(os.Stderr, "cannot open database")
+		return 1
+	}
+	tools := []mcpTool{
+		{Name: "echo", Description: "Echo text", InputSchema: json.RawMessage(`{"type":"object","properties":{"text":{"type":["string","null"]},"times":{"type":"integer"}},"required":["text"]}`)},
+		{Name: "fail", InputSchema: json.RawMessage(`{"type":"object"}`)},
+		{Name: "env.get", InputSchema: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}}}`)},
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		var req rpcMessage
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil || req.ID == nil {
+			continue
+		}
+		resp := rpcMessage{JSONRPC: "2.0", ID: req.ID}
+		var result any
+		switch req.Method {
+		case "initialize":
+			result = map[string]any{"protocolVersion": "2024-11-05", "capabilities": map[string]any{"tools": map[string]any{}}}
+		case "tools/list":
+			var params struct{ Cursor string }
+			json.Unmarshal(req.Params, &params)
+			if params.Cursor == "" {
+				result = map[string]any{"tools": tools[:2], "nextCursor": "2"}
+			} else {
+				result = map[string]any{"tools": tools[2:]}
+			}
+		case "tools/call":
+			out.Encode(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage(`"ping-1"`), Method: "ping"})
+			var params struct {
+				Name      string
+				Arguments map[string]any
+			}
+			json.Unmarshal(req.Params, &params)
+			switch params.Name {
+			case "echo":
+				result = mcpCallResult{Content: []mcpContent{{Type: "text", Text: fmt.Sprint(params.Arguments["text"])}, {Type: "image", MimeType: "image/png"}}}
+			case "env.get":
+				name, _ := params.Arguments["name"].(string)
+				result = mcpCallResult{Content: []mcpContent{{Type: "text", Text: os.Getenv(name)}}}
+			default:
+				result = mcpCallResult{Content: []mcpContent{{Type: "text", Text: "it broke"}}, IsError: true}
+			}
+		default:
+			resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found"}
+		}
+		if result != nil {
+			resp.Result, _ = json.Marshal(result)
+		}
+		out.Encode(resp)
+	}
+	return 0
+}
+
+func testMCPServer(mode string) MCPServer {
+	return MCPServer{Command: os.Args[0], Env: map[string]string{"PIPELLM_TEST_MCP_SERVER": mode}}
+}
+
+func TestMCPClient(t *testing.T) {
+	ctx := context.Background()
+	c, err := startMCP(ctx, "test", testMCPServer("serve"), &Sandbox{})
+	if err != nil {
+		t.Fatalf("startMCP failed: %v", err)
+	}
+	defer c.Close()
+
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"echo", "fail", "env.get"}) {
+		t.Errorf("Expected tools of both pages, got %q", names)
+	}
+
+	out, err := c.CallTool(ctx, "echo", []byte(`{"text":"hello"}`))
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if out != "hello\n[image/png content omitted]" {
+		t.Errorf("Unexpected output %q", out)
+	}
+	if _, err := c.CallTool(ctx, "fail", []byte(`{}`)); err == nil || err.Error() != "it broke" {
+		t.Errorf("Expected the tool's error, got %v", err)
+	}
+}
+
+func TestMCPServerCrash(t *testing.T) {
+	_, err := startMCP(context.Background(), "db", testMCPServer("crash"), &Sandbox{})
+	if err == nil || !strings.Contains(err.Error(), "mcp server db") || !strings.Contains(err.Error(), "cannot open database") {
+		t.Errorf("Expected an error with the server's output, got %v", err)
+	}
+
+	_, err = startMCP(context.Background(), "missing", MCPServer{Command: filepath.Join(t.TempDir(), "missing")}, &Sandbox{})
+	if err == nil {
+		t.Error("Expected an error for a missing command")
+	}
+}
+
+func TestMCPToolRunner(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "tools.jsonl")
+	t.Setenv("MCP_SECRET", "leaked")
+	cfg := &Config{
+		Sandbox:      Sandbox{AuditLog: log},
+		MCPServers:   map[string]MCPServer{"test": testMCPServer("serve")},
+		AllowedTools: []string{"local", "test__e*"},
+	}
+	prompt := &Prompt{
+		Name:  "agent",
+		Tools: []Tool{{ToolSpec: ToolSpec{Name: "local"}, Command: "echo local"}},
+		MCP:   []string{"test"},
+	}
+	ctx := context.Background()
+	r, err := newToolRunner(ctx, cfg, prompt, false)
+	if err != nil {
+		t.Fatalf("newToolRunner failed: %v", err)
+	}
+	defer r.Close()
+
+	var names []string
+	for _, s := range r.specs {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"local", "test__echo", "test__env_get"}) {
+		t.Fatalf("Expected local and allowed MCP tools, got %q", names)
+	}
+	params := r.specs[1].Parameters
+	if params.Type != "object" || params.Properties["text"].Type != "string" || !params.Properties["text"].Nullable || params.Required[0] != "text" {
+		t.Errorf("Unexpected parameters: %+v", params)
+	}
+
+	res := r.run(ctx, ToolCall{Name: "test__echo", Args: map[string]any{"text": "hi"}})
+	if res.Error != "" || !strings.HasPrefix(res.Output, "hi\n") {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	// The server gets the scrubbed environment plus its own variables.
+	res = r.run(ctx, ToolCall{Name: "test__env_get", Args: map[string]any{"name": "MCP_SECRET"}})
+	if res.Error != "" || res.Output != "" {
+		t.Errorf("Expected the environment to be scrubbed, got %+v", res)
+	}
+	res = r.run(ctx, ToolCall{Name: "test__env_get", Args: map[string]any{"name": "PIPELLM_TEST_MCP_SERVER"}})
+	if res.Output != "serve" {
+		t.Errorf("Expected the server's own variable, got %+v", res)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	var entry AuditEntry
+	json.Unmarshal([]byte(strings.SplitN(string(data), "\n", 2)[0]), &entry)
+	if entry.Tool != "test__echo" || entry.Command != "mcp:test/echo" || entry.ExitCode != 0 {
+		t.Errorf("Unexpected audit entry %+v", entry)
+	}
+
+	cfg.MCPServers["test"] = testMCPServer("crash")
+	if _, err := newToolRunner(ctx, cfg, prompt, false); err == nil {
+		t.Error("Expected an error when a server fails to start")
+	}
+}
+
+func TestMCPToolRunnerOutputLimit(t *testing.T) {
+	cfg := &Config{
+		AllowedTools: []string{"test__*"},
+		Sandbox:      Sandbox{MaxOutputKB: 1, AuditLog: filepath.Join(t.TempDir(), "tools.jsonl")},
+		MCPServers:   map[string]MCPServer{"test": testMCPServer("serve")},
+	}
+	ctx := context.Background()
+	r, err := newToolRunner(ctx, cfg, &Prompt{Name: "agent", MCP: []string{"test"}}, false)
+	if err != nil {
+		t.Fatalf("newToolRunner failed: %v", err)
+	}
+	defer r.Close()
+
+	res := r.run(ctx, ToolCall{Name: "test__echo", Args: map[string]any{"text": strings.Repeat("x", 3000)}})
+	if !strings.HasPrefix(res.Output, strings.Repeat("x", 1024)+"\n[output truncated at 1 KB]") {
+		t.Errorf("Expected the output to be cut off at 1 KB, got %d bytes: %.80q", len(res.Output), res.Output)
+	}
+}
+
+func TestMCPSchema(t *testing.T) {
+	s, err := mcpSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"paths": {"type": "array", "description": "Files"},
+			"mode": {"enum": ["read", "write"]},
+			"options": {"properties": {"depth": {"type": "number"}}},
+			"any": {}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("mcpSchema failed: %v", err)
+	}
+	expected := &Schema{Type: "object", Properties: map[string]*Schema{
+		"paths":   {Type: "array", Description: "Files", Items: &Schema{Type: "string"}},
+		"mode":    {Type: "string", Enum: []string{"read", "write"}},
+		"options": {Type: "object", Properties: map[string]*Schema{"depth": {Type: "number"}}},
+		"any":     {Type: "string"},
+	}}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, s)
+	}
+	if err := s.validate("test"); err != nil {
+		t.Errorf("Expected a valid schema, got %v", err)
+	}
+
+	if s, err := mcpSchema(json.RawMessage(`{"type":"object"}`)); s != nil || err != nil {
+		t.Errorf("Expected no parameters for an empty object, got %+v, %v", s, err)
+	}
+	if _, err := mcpSchema(json.RawMessage(`[`)); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestMCPToolName(t *testing.T) {
+	if got := mcpToolName("git", "log.show"); got != "git__log_show" {
+		t.Errorf("Expected git__log_show, got %q", got)
+	}
+	if got := mcpToolName("fs", strings.Repeat("x", 80)); len(got) != 64 || !toolNameRe.MatchString(got) {
+		t.Errorf("Expected a valid name of 64 characters, got %q", got)
+	}
+}
+
+func TestMCPConfigValidate(t *testing.T) {
+	cfg := &Config{
+		MCPServers: map[string]MCPServer{"fs": {Command: "mcp-fs"}, "no command": {}},
+		Prompts:    []Prompt{{Name: "agent", Prompt: "Do things", MCP: []string{"fs", "git"}}},
+	}
+	err := cfg.Validate()
+	for _, expected := range []string{`invalid mcp server name "no command"`, `unknown mcp server "git"`} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	rpcParseError    = -32700
+	rpcInvalidParams = -32602
+)
+
+func cmdMCP(args []string, profile string) error {
+	fs := flag.NewFlagSet("mcp", flag.ContinueOnError)
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 || rest[0] != "serve" {
+		return fmt.Errorf("usage: pipellm mcp serve")
+	}
+
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+	return newMCPServer(cfg, os.Stdout).serve(context.Background(), os.Stdin)
+}
+
+// mcpServer offers the config's prompts as MCP tools over stdin and
+// stdout. Each tool takes the prompt's input and its variables.
+type mcpServer struct {
+	cfg     *Config
+	tools   []mcpTool
+	prompts map[string]string // tool name to prompt name
+
+	mu  sync.Mutex
+	out *json.Encoder
+}
+
+func newMCPServer(cfg *Config, out io.Writer) *mcpServer {
+	s := &mcpServer{cfg: cfg, prompts: make(map[string]string), out: json.NewEncoder(out)}
+	for _, p := range cfg.Prompts {
+		name := mcpToolNameRe.ReplaceAllString(p.Name, "_")
+		if _, ok := s.prompts[name]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: skipping prompt %q, another prompt has the tool name %s\n", p.Name, name)
+			continue
+		}
+		s.prompts[name] = p.Name
+		description := p.Description
+		if description == "" {
+			description = fmt.Sprintf("Runs the %s prompt.", p.Name)
+		}
+		schema, _ := json.Marshal(promptSchema(&p))
+		s.tools = append(s.tools, mcpTool{Name: name, Description: description, InputSchema: schema})
+	}
+	return s
+}
+
+// promptSchema describes the arguments of a prompt's tool.
+func promptSchema(p *Prompt) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{
+		"input": {Type: "string", Description: "Text for the prompt to work on, added after the prompt"},
+	}}
+	for _, v := range p.Variables {
+		s.Properties[v.Name] = &Schema{Type: "string", Description: v.Description}
+		if v.Default == nil {
+			s.Required = append(s.Required, v.Name)
+		}
+	}
+	return s
+}
+
+// serve answers requests from in until it ends. Tool calls run
+// concurrently; everything else is answered in order.
+func (s *mcpServer) serve(ctx context.Context, in io.Reader) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), mcpMaxMessage)
+	for scanner.Scan() {
+		var req rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			s.write(rpcMessage{ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: "parse error"}})
+			continue
+		}
+		if req.ID == nil || req.Method == "" {
+			// Notifications need no answer and we send no requests.
+			continue
+		}
+		if req.Method == "tools/call" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.write(s.handle(ctx, req))
+			}()
+			continue
+		}
+		s.write(s.handle(ctx, req))
+	}
+	return scanner.Err()
+}
+
+func (s *mcpServer) write(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.out.Encode(msg); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: writing mcp response: %v\n", err)
+	}
+}
+
+func (s *mcpServer) handle(ctx context.Context, req rpcMessage) rpcMessage {
+	var (
+		result any
+		err    *rpcError
+	)
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := mcpProtocolVersion
+		if mcpProtocolVersions[params.ProtocolVersion] {
+			version = params.ProtocolVersion
+		}
+		result = map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": "pipellm", "version": "1"},
+		}
+	case "ping":
+		result = map[string]any{}
+	case "tools/list":
+		result = map[string]any{"tools": s.tools}
+	case "tools/call":
+		result, err = s.call(ctx, req.Params)
+	default:
+		err = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+
+	resp := rpcMessage{ID: req.ID}
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+	resp.Result, _ = json.Marshal(result)
+	return resp
+}
+
+// call runs a prompt. Failures of the prompt are reported in the result
+// so that the model calling it can see them; only invalid calls are
+// protocol errors.
+func (s *mcpServer) call(ctx context.Context, data json.RawMessage) (*mcpCallResult, *rpcError) {
+	var params struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	name, ok := s.prompts[params.Name]
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown tool: " + params.Name}
+	}
+
+	var input string
+	values := make(map[string]string)
+	for k, v := range params.Arguments {
+		str, ok := v.(string)
+		if !ok {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("argument %s must be a string", k)}
+		}
+		if k == "input" {
+			input = str
+		} else {
+			values[k] = str
+		}
+	}
+
+	text, err := s.runPrompt(ctx, name, input, values)
+	if err != nil {
+		return &mcpCallResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+	return &mcpCallResult{Content: []mcpContent{{Type: "text", Text: text}}}, nil
+}
+
+func (s *mcpServer) runPrompt(ctx context.Context, name, input string, values map[string]string) (string, error) {
+	prompt, err := s.cfg.ResolvePrompt(name).Expand(values)
+	if err != nil {
+		return "", err
+	}
+	req := NewRequest(prompt, input)
+	tools, err := newServerToolRunner(ctx, s.cfg, prompt)
+	if err != nil {
+		return "", err
+	}
+	if tools != nil {
+		defer tools.Close()
+		req.Tools = tools.specs
+	}
+	_, res, err := answer(ctx, s.cfg, prompt, req, tools, &runOptions{})
+	if err != nil {
+		return "", err
+	}
+	return res.resp.Text, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMCPServe(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	var received []ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		received = append(received, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":       req.Model,
+			"message":     map[string]string{"role": "assistant", "content": "Looks good."},
+			"done_reason": "stop",
+		})
+	}))
+	defer server.Close()
+
+	language := "Go"
+	cfg := &Config{Provider: "ollama", Endpoint: server.URL, Model: "llama3", Prompts: []Prompt{
+		{Name: "tech review", Description: "Review a change", Prompt: "Review this {{language}} code for {{focus}}:", Variables: []Variable{
+			{Name: "language", Default: &language},
+			{Name: "focus", Description: "What to look at"},
+		}},
+		{Name: "summarize", Prompt: "Summarize:"},
+	}}
+
+	requests := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"editor"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"tech_review","arguments":{"input":"x := 1","focus":"naming"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"tech_review","arguments":{"input":"x := 1"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"missing","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"summarize","arguments":{"input":42}}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"resources/list"}`,
+		`not json`,
+	}
+	var out bytes.Buffer
+	if err := newMCPServer(cfg, &out).serve(context.Background(), strings.NewReader(strings.Join(requests, "\n"))); err != nil {
+		t.Fatalf("serve failed: %v", err)
+	}
+
+	responses := make(map[string]rpcMessage)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg rpcMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("Invalid response %q: %v", line, err)
+		}
+		responses[string(msg.ID)] = msg
+	}
+	if len(responses) != 8 {
+		t.Errorf("Expected 8 responses, got %d:\n%s", len(responses), out.String())
+	}
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(responses["1"].Result, &init)
+	if init.ProtocolVersion != "2025-03-26" {
+		t.Errorf("Expected the client's protocol version, got %q", init.ProtocolVersion)
+	}
+
+	var list struct {
+		Tools []struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			InputSchema Schema `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	json.Unmarshal(responses["2"].Result, &list)
+	if len(list.Tools) != 2 || list.Tools[0].Name != "tech_review" || list.Tools[1].Description != "Runs the summarize prompt." {
+		t.Fatalf("Unexpected tools %+v", list.Tools)
+	}
+	schema := list.Tools[0].InputSchema
+	if schema.Type != "object" || schema.Properties["input"] == nil || schema.Properties["focus"].Description != "What to look at" || !reflect.DeepEqual(schema.Required, []string{"focus"}) {
+		t.Errorf("Unexpected input schema %+v", schema)
+	}
+
+	var result mcpCallResult
+	json.Unmarshal(responses["3"].Result, &result)
+	if result.IsError || result.text() != "Looks good." {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(received) != 1 || received[0].Messages[0].Content != "Review this Go code for naming:\n\nx := 1" {
+		t.Errorf("Unexpected requests to the model: %+v", received)
+	}
+
+	result = mcpCallResult{}
+	json.Unmarshal(responses["4"].Result, &result)
+	if !result.IsError || !strings.Contains(result.text(), "needs variable focus") {
+		t.Errorf("Expected an error result for a missing variable, got %+v", result)
+	}
+
+	for id, code := range map[string]int{"5": rpcInvalidParams, "6": rpcInvalidParams, "7": rpcMethodNotFound, "null": rpcParseError} {
+		if e := responses[id].Error; e == nil || e.Code != code {
+			t.Errorf("Expected error %d for request %s, got %+v", code, id, responses[id])
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultOllamaEndpoint = "http://localhost:11434"
+
+type OllamaClient struct {
+	httpClient *http.Client
+	endpoint   string
+	model      string
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description,omitempty"`
+		Parameters  *Schema `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type ollamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	TopK        *int32   `json:"top_k,omitempty"`
+	NumPredict  *int32   `json:"num_predict,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaFinishReasons maps Ollama done reasons to Gemini finish reasons.
+var ollamaFinishReasons = map[string]string{
+	"stop":   "STOP",
+	"length": "MAX_TOKENS",
+}
+
+func NewOllamaClient(endpoint, modelName string) *OllamaClient {
+	if endpoint == "" {
+		endpoint = defaultOllamaEndpoint
+	}
+	return &OllamaClient{
+		httpClient: http.DefaultClient,
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		model:      modelName,
+	}
+}
+
+func newOllamaRequest(modelName string, req *Request) ollamaChatRequest {
+	var messages []ollamaMessage
+	if req.System != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.History {
+		messages = append(messages, newOllamaMessages(m)...)
+	}
+	messages = append(messages, newOllamaMessages(req.turn())...)
+
+	out := ollamaChatRequest{
+		Model:    modelName,
+		Messages: messages,
+	}
+	for _, spec := range req.Tools {
+		tool := ollamaTool{Type: "function"}
+		tool.Function.Name = spec.Name
+		tool.Function.Description = spec.Description
+		tool.Function.Parameters = spec.Parameters
+		out.Tools = append(out.Tools, tool)
+	}
+	if req.Params != (Params{}) {
+		out.Options = &ollamaOptions{
+			Temperature: req.Params.Temperature,
+			TopP:        req.Params.TopP,
+			TopK:        req.Params.TopK,
+			NumPredict:  req.Params.MaxOutputTokens,
+		}
+	}
+	return out
+}
+
+// newOllamaMessages maps a turn to Ollama messages. Tool results are
+// separate messages with the role "tool".
+func newOllamaMessages(m Message) []ollamaMessage {
+	var out []ollamaMessage
+	for _, r := range m.Results {
+		content, _ := json.Marshal(r.response())
+		out = append(out, ollamaMessage{Role: "tool", Content: string(content), ToolName: r.Name})
+	}
+	if len(m.Results) > 0 && len(m.Parts) == 0 {
+		return out
+	}
+
+	role := m.Role
+	if role == "model" {
+		role = "assistant"
+	}
+	msg := ollamaMessage{Role: role, Content: strings.Join(m.Parts, "\n\n")}
+	for _, c := range m.Calls {
+		var call ollamaToolCall
+		call.Function.Name, call.Function.Arguments = c.Name, c.Args
+		msg.ToolCalls = append(msg.ToolCalls, call)
+	}
+	return append(out, msg)
+}
+
+func (c *OllamaClient) Generate(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := c.post(ctx, newOllamaRequest(c.model, req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama response: %w", err)
+	}
+	return out.response()
+}
+
+// GenerateStream is Generate, passing on the text as it arrives. Ollama
+// streams one JSON object per line; the last one is done.
+func (c *OllamaClient) GenerateStream(ctx context.Context, req *Request, onText func(string)) (*Response, error) {
+	chatReq := newOllamaRequest(c.model, req)
+	chatReq.Stream = true
+	resp, err := c.post(ctx, chatReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ollamaChatResponse
+	var text strings.Builder
+	var calls []ollamaToolCall
+	dec := json.NewDecoder(resp.Body)
+	for !out.Done {
+		var chunk ollamaChatResponse
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("failed to parse Ollama response: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, &APIError{Provider: "ollama", StatusCode: resp.StatusCode, Message: chunk.Error}
+		}
+		if chunk.Message.Content != "" {
+			onText(chunk.Message.Content)
+		}
+		text.WriteString(chunk.Message.Content)
+		calls = append(calls, chunk.Message.ToolCalls...)
+		out = chunk
+	}
+	out.Message.Content, out.Message.ToolCalls = text.String(), calls
+	return out.response()
+}
+
+// post sends body to the chat endpoint and returns the response if its
+// status is OK.
+func (c *OllamaClient) post(ctx context.Context, body ollamaChatRequest) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/api/chat", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var out ollamaChatResponse
+	if data, err := io.ReadAll(resp.Body); err == nil && json.Unmarshal(data, &out) == nil && out.Error != "" {
+		return nil, &APIError{Provider: "ollama", StatusCode: resp.StatusCode, Message: out.Error}
+	}
+	return nil, &APIError{Provider: "ollama", StatusCode: resp.StatusCode, Message: "unexpected status " + resp.Status}
+}
+
+// response converts out, classifying answers without text.
+func (out *ollamaChatResponse) response() (*Response, error) {
+	finishReason, ok := ollamaFinishReasons[out.DoneReason]
+	if !ok {
+		finishReason = strings.ToUpper(out.DoneReason)
+	}
+	var calls []ToolCall
+	for _, call := range out.Message.ToolCalls {
+		calls = append(calls, ToolCall{Name: call.Function.Name, Args: call.Function.Arguments})
+	}
+	if out.Message.Content == "" && len(calls) == 0 {
+		return nil, checkFinish("Ollama", finishReason, nil)
+	}
+
+	return &Response{
+		ToolCalls:    calls,
+		Text:         out.Message.Content,
+		Model:        out.Model,
+		FinishReason: finishReason,
+		Usage: Usage{
+			InputTokens:  out.PromptEvalCount,
+			OutputTokens: out.EvalCount,
+			TotalTokens:  out.PromptEvalCount + out.EvalCount,
+		},
+	}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaClientSendPrompt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("Expected path '/api/chat', got %s", r.URL.Path)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("Failed to read request body: %v", err)
+		}
+
+		var req ollamaChatRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("Failed to unmarshal request body: %v", err)
+		}
+
+		if req.Model != "llama3" {
+			t.Errorf("Expected model 'llama3', got %q", req.Model)
+		}
+		if req.Stream {
+			t.Error("Expected non-streaming request")
+		}
+		if len(req.Messages) != 1 || req.Messages[0].Content != "Test prompt\n\nTest input" {
+			t.Errorf("Unexpected messages: %+v", req.Messages)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "Local response"}, "done": true}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL+"/", "llama3")
+	resp, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, "Test input"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if resp.Text != "Local response" {
+		t.Errorf("Expected response %q, got %q", "Local response", resp.Text)
+	}
+}
+
+func TestOllamaClientSendPromptError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "model \"llama3\" not found"}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "llama3")
+	_, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""))
+	if err == nil {
+		t.Fatal("Expected error for missing model, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected Ollama error message, got %v", err)
+	}
+}
+
+func TestOllamaClientGenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Error("Expected a streaming request")
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, `{"model": "llama3", "message": {"role": "assistant", "content": "Local "}, "done": false}`+"\n")
+		io.WriteString(w, `{"model": "llama3", "message": {"role": "assistant", "content": "response"}, "done": false}`+"\n")
+		io.WriteString(w, `{"model": "llama3", "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop", "prompt_eval_count": 7, "eval_count": 2}`+"\n")
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "llama3")
+	var texts []string
+	resp, err := client.GenerateStream(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""), func(text string) {
+		texts = append(texts, text)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream failed: %v", err)
+	}
+
+	if strings.Join(texts, "|") != "Local |response" {
+		t.Errorf("Expected the text in two pieces, got %q", texts)
+	}
+	if resp.Text != "Local response" || resp.FinishReason != "STOP" || resp.Usage.TotalTokens != 9 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
+
+func TestOllamaClientGenerateStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"message": {"role": "assistant", "content": "Local "}, "done": false}`+"\n")
+		io.WriteString(w, `{"error": "model runner has unexpectedly stopped"}`+"\n")
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "llama3")
+	_, err := client.GenerateStream(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""), func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "unexpectedly stopped") {
+		t.Errorf("Expected the error of the stream, got %v", err)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openaiPromptPrefix selects a prompt rather than a model in the model
+// field of OpenAI requests, as in "prompt:review".
+const openaiPromptPrefix = "prompt:"
+
+// openaiAdHocPrompt names calls of plain models in the usage ledger.
+const openaiAdHocPrompt = "chat-completions"
+
+type openaiChatRequest struct {
+	Model               string          `json:"model"`
+	Messages            []openaiMessage `json:"messages"`
+	Temperature         *float32        `json:"temperature"`
+	TopP                *float32        `json:"top_p"`
+	MaxTokens           *int32          `json:"max_tokens"`
+	MaxCompletionTokens *int32          `json:"max_completion_tokens"`
+	N                   int             `json:"n"`
+	Stream              bool            `json:"stream"`
+	StreamOptions       struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Tools []json.RawMessage `json:"tools"`
+}
+
+type openaiMessage struct {
+	Role    string          `json:"role,omitempty"`
+	Content json.RawMessage `json:"content,omitempty"`
+}
+
+// text returns the message content, which is a string or a list of
+// parts of which only text is supported.
+func (m *openaiMessage) text() (string, error) {
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("invalid %s message content", m.Role)
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type != "text" {
+			return "", fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+type openaiChoice struct {
+	Index        int          `json:"index"`
+	Message      *openaiReply `json:"message,omitempty"`
+	Delta        *openaiReply `json:"delta,omitempty"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+type openaiReply struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openaiUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openaiChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openaiChoice `json:"choices"`
+	Usage   *openaiUsage   `json:"usage,omitempty"`
+}
+
+// openaiFinishReasons maps Gemini finish reasons to OpenAI ones; others
+// become "stop".
+var openaiFinishReasons = map[string]string{
+	"MAX_TOKENS": "length",
+	"SAFETY":     "content_filter",
+	"RECITATION": "content_filter",
+}
+
+// openaiError is an error in the format OpenAI clients expect.
+type openaiError struct {
+	status int
+	Type   string `json:"type"`
+	Code   string `json:"code,omitempty"`
+	Msg    string `json:"message"`
+}
+
+func (e *openaiError) Error() string { return e.Msg }
+
+func invalidRequest(format string, args ...any) *openaiError {
+	return &openaiError{status: http.StatusBadRequest, Type: "invalid_request_error", Msg: fmt.Sprintf(format, args...)}
+}
+
+func writeOpenAIError(w http.ResponseWriter, err error) {
+	var e *openaiError
+	if !errors.As(err, &e) {
+		e = &openaiError{status: errorStatus(err), Type: "api_error", Msg: err.Error()}
+	}
+	writeJSON(w, e.status, map[string]any{"error": e})
+}
+
+// listModels answers GET /v1/models with the prompts and the default
+// model.
+func (s *apiServer) listModels(w http.ResponseWriter, r *http.Request) {
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+	var models []model
+	for _, p := range s.cfg.Prompts {
+		models = append(models, model{ID: openaiPromptPrefix + strings.TrimSpace(p.Name), Object: "model", OwnedBy: "pipellm"})
+	}
+	for _, m := range s.adHocPrompt("").Models() {
+		models = append(models, model{ID: m, Object: "model", OwnedBy: providerName(s.cfg)})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+}
+
+// chatCompletions answers POST /v1/chat/completions. The model may be a
+// prompt, whose text goes before the first user message, or a model
+// name for the configured backends.
+func (s *apiServer) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	// Unlike text/plain, a JSON body cannot come from a plain HTML form.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeOpenAIError(w, &openaiError{status: http.StatusUnsupportedMediaType, Type: "invalid_request_error", Msg: "Content-Type must be application/json"})
+		return
+	}
+	var body openaiChatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil {
+		writeOpenAIError(w, invalidRequest("invalid JSON body: %v", err))
+		return
+	}
+	prompt, err := s.openaiPrompt(body.Model)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	req, err := openaiRequest(prompt, &body)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	if body.Stream {
+		s.streamCompletion(w, r, prompt, req, &body)
+		return
+	}
+	env, err := s.answer(r.Context(), prompt, req, nil, nil)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	resp := openaiChatResponse{
+		ID:      newCompletionID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   env.Model,
+		Usage:   newOpenAIUsage(env),
+	}
+	finish := openaiFinishReason(env)
+	resp.Choices = []openaiChoice{{Message: &openaiReply{Role: "assistant", Content: env.Text}, FinishReason: &finish}}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// streamCompletion answers a chat request with stream set: a chunk with
+// the role, one for every piece of text as the model writes it, one with
+// the finish reason and, if asked for, one with the usage.
+func (s *apiServer) streamCompletion(w http.ResponseWriter, r *http.Request, prompt *Prompt, req *Request, body *openaiChatRequest) {
+	stream := newEventStream(w)
+	stop := stream.keepAlive(sseKeepAlive)
+	chunk := openaiChatResponse{
+		ID:      newCompletionID(),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   body.Model,
+	}
+	send := func(choice openaiChoice) {
+		c := chunk
+		c.Choices = []openaiChoice{choice}
+		stream.sendData(c)
+	}
+	send(openaiChoice{Delta: &openaiReply{Role: "assistant"}})
+	env, err := s.answer(r.Context(), prompt, req, nil, func(text string) {
+		send(openaiChoice{Delta: &openaiReply{Content: text}})
+	})
+	stop()
+	if err != nil {
+		var e *openaiError
+		if !errors.As(err, &e) {
+			e = &openaiError{Type: "api_error", Msg: err.Error()}
+		}
+		stream.sendData(map[string]any{"error": e})
+		return
+	}
+
+	finish := openaiFinishReason(env)
+	send(openaiChoice{Delta: &openaiReply{}, FinishReason: &finish})
+	if body.StreamOptions.IncludeUsage {
+		chunk.Choices, chunk.Usage = []openaiChoice{}, newOpenAIUsage(env)
+		stream.sendData(chunk)
+	}
+	stream.write("data: [DONE]\n\n")
+}
+
+func openaiFinishReason(env *Envelope) string {
+	if reason, ok := openaiFinishReasons[env.FinishReason]; ok {
+		return reason
+	}
+	return "stop"
+}
+
+func newOpenAIUsage(env *Envelope) *openaiUsage {
+	return &openaiUsage{
+		PromptTokens:     env.Usage.InputTokens,
+		CompletionTokens: env.Usage.OutputTokens,
+		TotalTokens:      env.Usage.InputTokens + env.Usage.OutputTokens,
+	}
+}
+
+// openaiPrompt returns the prompt selected by an OpenAI model field.
+func (s *apiServer) openaiPrompt(model string) (*Prompt, error) {
+	name, ok := strings.CutPrefix(model, openaiPromptPrefix)
+	if !ok {
+		return s.adHocPrompt(model), nil
+	}
+	prompt := s.cfg.ResolvePrompt(name)
+	if prompt == nil {
+		return nil, &openaiError{status: http.StatusNotFound, Type: "invalid_request_error", Code: "model_not_found", Msg: fmt.Sprintf("no prompt found for name: %s", name)}
+	}
+	prompt, err := prompt.Expand(nil)
+	if err != nil {
+		return nil, invalidRequest("%v", err)
+	}
+	return prompt, nil
+}
+
+// adHocPrompt returns a prompt without text for model, with the
+// top-level settings. An empty model means the configured ones.
+func (s *apiServer) adHocPrompt(model string) *Prompt {
+	p := &Prompt{Name: openaiAdHocPrompt, Model: model, Params: s.cfg.Params, Safety: s.cfg.Safety}
+	if model == "" {
+		p.Model, p.Fallbacks = s.cfg.Model, s.cfg.Fallbacks
+	}
+	if p.Model == "" {
+		p.Model = defaultModels[providerName(s.cfg)]
+	}
+	return p
+}
+
+// openaiRequest translates an OpenAI chat request for prompt. System
+// messages are added to the prompt's system instruction and the last
+// message, which must be the user's, is the new turn.
+func openaiRequest(prompt *Prompt, body *openaiChatRequest) (*Request, error) {
+	if len(body.Tools) > 0 {
+		return nil, invalidRequest("tools are not supported; use a prompt that declares them")
+	}
+	if body.N > 1 {
+		return nil, invalidRequest("n must be 1")
+	}
+
+	system := []string{}
+	if prompt.System != "" {
+		system = append(system, prompt.System)
+	}
+	var messages []Message
+	prepended := false
+	for _, m := range body.Messages {
+		text, err := m.text()
+		if err != nil {
+			return nil, invalidRequest("%v", err)
+		}
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, text)
+		case "user":
+			if !prepended && prompt.Prompt != "" {
+				// As in chat, the prompt text goes with the first user
+				// message, even after assistant ones.
+				text = prompt.Prompt + "\n\n" + text
+				prepended = true
+			}
+			messages = append(messages, Message{Role: "user", Parts: []string{text}})
+		case "assistant":
+			messages = append(messages, Message{Role: "model", Parts: []string{text}})
+		default:
+			return nil, invalidRequest("unsupported message role %q", m.Role)
+		}
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+		return nil, invalidRequest("the last message must be from the user")
+	}
+
+	params := prompt.Params
+	if body.Temperature != nil {
+		params.Temperature = body.Temperature
+	}
+	if body.TopP != nil {
+		params.TopP = body.TopP
+	}
+	if body.MaxTokens != nil {
+		params.MaxOutputTokens = body.MaxTokens
+	}
+	if body.MaxCompletionTokens != nil {
+		params.MaxOutputTokens = body.MaxCompletionTokens
+	}
+
+	last := messages[len(messages)-1]
+	return &Request{
+		System:  strings.Join(system, "\n\n"),
+		History: messages[:len(messages)-1],
+		Parts:   last.Parts,
+		Params:  params,
+		Safety:  prompt.Safety,
+	}, nil
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChatCompletions(t *testing.T) {
+	server, backend := newTestAPI(t, "secret")
+	url := server.URL + "/v1/chat/completions"
+
+	resp, body := doRequest(t, "POST", url, "secret", "application/json", `{
+		"model": "prompt:summarize",
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": "First text"},
+			{"role": "assistant", "content": "A summary."},
+			{"role": "user", "content": [{"type": "text", "text": "Second text"}]}
+		],
+		"temperature": 0.2,
+		"max_tokens": 100
+	}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", resp.StatusCode, body)
+	}
+	var completion openaiChatResponse
+	if err := json.Unmarshal([]byte(body), &completion); err != nil {
+		t.Fatalf("Invalid response %s: %v", body, err)
+	}
+	if completion.Object != "chat.completion" || !strings.HasPrefix(completion.ID, "chatcmpl-") || completion.Model != "llama3" {
+		t.Errorf("Unexpected completion %+v", completion)
+	}
+	if c := completion.Choices; len(c) != 1 || c[0].Message.Content != "Looks good." || c[0].Message.Role != "assistant" || *c[0].FinishReason != "stop" {
+		t.Errorf("Unexpected choices %+v", c)
+	}
+	if u := completion.Usage; u.PromptTokens != 10 || u.CompletionTokens != 5 || u.TotalTokens != 15 {
+		t.Errorf("Unexpected usage %+v", u)
+	}
+
+	sent := backend.requests[0]
+	var contents []string
+	for _, m := range sent.Messages {
+		contents = append(contents, m.Role+": "+m.Content)
+	}
+	expected := []string{"system: Be brief.", "user: Summarize:\n\nFirst text", "assistant: A summary.", "user: Second text"}
+	if strings.Join(contents, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected messages %q, got %q", expected, contents)
+	}
+	if o := sent.Options; o == nil || *o.Temperature != 0.2 || *o.NumPredict != 100 {
+		t.Errorf("Expected the request's params, got %+v", o)
+	}
+
+	// Any other model is used as it is.
+	resp, body = doRequest(t, "POST", url, "secret", "application/json", `{"model": "codellama", "messages": [{"role": "user", "content": "hi"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", resp.StatusCode, body)
+	}
+	if sent := backend.requests[1]; sent.Model != "codellama" || len(sent.Messages) != 1 || sent.Messages[0].Content != "hi" {
+		t.Errorf("Unexpected request to the model: %+v", sent)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+		msg    string
+	}{
+		{`{"model": "prompt:missing", "messages": [{"role": "user", "content": "hi"}]}`, http.StatusNotFound, "no prompt found"},
+		{`{"model": "prompt:review", "messages": [{"role": "user", "content": "hi"}]}`, http.StatusBadRequest, "needs variable language"},
+		{`{"model": "llama3", "messages": [{"role": "assistant", "content": "hi"}]}`, http.StatusBadRequest, "last message must be from the user"},
+		{`{"model": "llama3", "messages": [{"role": "user", "content": [{"type": "image_url"}]}]}`, http.StatusBadRequest, "unsupported content part"},
+		{`{"model": "llama3", "messages": [{"role": "user", "content": "hi"}], "tools": [{"type": "function"}]}`, http.StatusBadRequest, "tools are not supported"},
+		{`{"model": "llama3", "messages": [{"role": "user", "content": "hi"}], "n": 2}`, http.StatusBadRequest, "n must be 1"},
+		{`{`, http.StatusBadRequest, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		resp, body := doRequest(t, "POST", url, "secret", "application/json; charset=utf-8", tt.body)
+		var e struct{ Error openaiError }
+		json.Unmarshal([]byte(body), &e)
+		if resp.StatusCode != tt.status || !strings.Contains(e.Error.Msg, tt.msg) || e.Error.Type == "" {
+			t.Errorf("%s: expected %d with %q, got %d %s", tt.body, tt.status, tt.msg, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestOpenAIRequestPrependsPrompt(t *testing.T) {
+	var body openaiChatRequest
+	json.Unmarshal([]byte(`{"messages": [
+		{"role": "assistant", "content": "How can I help?"},
+		{"role": "user", "content": "First text"},
+		{"role": "assistant", "content": "A summary."},
+		{"role": "user", "content": "Second text"}
+	]}`), &body)
+	req, err := openaiRequest(&Prompt{Prompt: "Summarize:"}, &body)
+	if err != nil {
+		t.Fatalf("openaiRequest failed: %v", err)
+	}
+	if h := req.History; len(h) != 3 || h[0].Parts[0] != "How can I help?" || h[1].Parts[0] != "Summarize:\n\nFirst text" {
+		t.Errorf("Expected the prompt before the first user message, got %+v", h)
+	}
+	if req.Parts[0] != "Second text" {
+		t.Errorf("Expected the prompt only once, got %q", req.Parts)
+	}
+}
+
+func TestChatCompletionsContentType(t *testing.T) {
+	server, backend := newTestAPI(t, "")
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		resp, body := doRequest(t, "POST", server.URL+"/v1/chat/completions", "", contentType,
+			`{"model": "llama3", "messages": [{"role": "user", "content": "hi"}]}`)
+		if resp.StatusCode != http.StatusUnsupportedMediaType || !strings.Contains(body, "application/json") {
+			t.Errorf("Content-Type %q: expected 415, got %d %s", contentType, resp.StatusCode, body)
+		}
+	}
+	if len(backend.requests) != 0 {
+		t.Errorf("Expected no calls to the model, got %d", len(backend.requests))
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	server, backend := newTestAPI(t, "")
+	resp, body := doRequest(t, "POST", server.URL+"/v1/chat/completions", "", "application/json",
+		`{"model": "prompt:agent", "stream": true, "stream_options": {"include_usage": true}, "messages": [{"role": "user", "content": "the answer"}]}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s: %s", ct, body)
+	}
+
+	events := strings.Split(strings.TrimSpace(body), "\n\n")
+	if len(events) != 6 || events[5] != "data: [DONE]" {
+		t.Fatalf("Unexpected events:\n%s", body)
+	}
+	var chunks []openaiChatResponse
+	for _, e := range events[:5] {
+		var chunk openaiChatResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(e, "data: ")), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", e, err)
+		}
+		if chunk.Object != "chat.completion.chunk" || !strings.HasPrefix(chunk.ID, "chatcmpl-") || chunk.Model != "prompt:agent" {
+			t.Errorf("Unexpected chunk %+v", chunk)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if chunks[4].ID != chunks[0].ID {
+		t.Errorf("Expected the chunks to share an ID, got %s and %s", chunks[0].ID, chunks[4].ID)
+	}
+	if c := chunks[0]; c.Choices[0].Delta.Role != "assistant" || c.Choices[0].Delta.Content != "" {
+		t.Errorf("Expected the role first, got %+v", c)
+	}
+	// The text comes a piece at a time.
+	if c1, c2 := chunks[1].Choices[0], chunks[2].Choices[0]; c1.Delta.Content != "Looks " || c2.Delta.Content != "good." || c1.FinishReason != nil || c2.FinishReason != nil {
+		t.Errorf("Unexpected text chunks %+v and %+v", c1, c2)
+	}
+	if c := chunks[3]; *c.Choices[0].FinishReason != "stop" || c.Choices[0].Delta.Content != "" {
+		t.Errorf("Unexpected finish chunk %+v", c)
+	}
+	// The prompt's tools ran on the server.
+	if c := chunks[4]; len(c.Choices) != 0 || c.Usage.CompletionTokens != 10 {
+		t.Errorf("Expected usage of both rounds in the last chunk, got %+v", c)
+	}
+
+	backend.fail = true
+	_, body = doRequest(t, "POST", server.URL+"/v1/chat/completions", "", "application/json",
+		`{"model": "llama3", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`)
+	events = strings.Split(strings.TrimSpace(body), "\n\n")
+	if !strings.HasPrefix(events[len(events)-1], `data: {"error":{"type":"api_error"`) {
+		t.Errorf("Expected an error event, got %s", body)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	server, _ := newTestAPI(t, "")
+	_, body := doRequest(t, "GET", server.URL+"/v1/models", "", "", "")
+	var list struct {
+		Data []struct{ ID string }
+	}
+	json.Unmarshal([]byte(body), &list)
+	var ids []string
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	if strings.Join(ids, ",") != "prompt:review,prompt:summarize,prompt:agent,llama3" {
+		t.Errorf("Unexpected models %q", ids)
+	}
+}
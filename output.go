@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Envelope is the --output=json result of a prompt run.
+type Envelope struct {
+	Text          string         `json:"text"`
+	Provider      string         `json:"provider"`
+	Model         string         `json:"model"`
+	FinishReason  string         `json:"finish_reason,omitempty"`
+	BlockReason   string         `json:"block_reason,omitempty"`
+	SafetyRatings []SafetyRating `json:"safety_ratings,omitempty"`
+	Usage         Usage          `json:"usage"`
+	LatencyMs     int64          `json:"latency_ms"`
+	RequestID     string         `json:"request_id,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Cached        bool           `json:"cached,omitempty"`
+	Fallbacks     []Attempt      `json:"fallbacks,omitempty"`
+}
+
+func NewEnvelope(provider, model string, resp *Response, latency time.Duration) *Envelope {
+	// Backends that report the serving model version take precedence
+	// over the requested alias.
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	return &Envelope{
+		Text:          resp.Text,
+		Provider:      provider,
+		Model:         model,
+		FinishReason:  resp.FinishReason,
+		SafetyRatings: resp.SafetyRatings,
+		Usage:         resp.Usage,
+		LatencyMs:     latency.Milliseconds(),
+		RequestID:     resp.RequestID,
+	}
+}
+
+// NewErrorEnvelope returns the envelope for a run that failed with
+// respErr, found in err: a blocked, truncated or empty response.
+func NewErrorEnvelope(err error, respErr *ResponseError, latency time.Duration) *Envelope {
+	env := &Envelope{
+		Provider:      strings.ToLower(respErr.Provider),
+		FinishReason:  respErr.FinishReason,
+		BlockReason:   respErr.BlockReason,
+		SafetyRatings: respErr.SafetyRatings,
+		LatencyMs:     latency.Milliseconds(),
+		Error:         err.Error(),
+	}
+	var modelErr *modelError
+	if errors.As(err, &modelErr) {
+		env.Provider, env.Model, env.Fallbacks = modelErr.provider, modelErr.model, modelErr.failed
+	}
+	return env
+}
+
+func writeOutput(w io.Writer, format string, env *Envelope) error {
+	switch format {
+	case "text":
+		_, err := fmt.Fprintln(w, env.Text)
+		return err
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(env)
+	}
+	return fmt.Errorf("unknown output format %q (want text or json)", format)
+}
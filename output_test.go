@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWriteOutputJSON(t *testing.T) {
+	resp := &Response{
+		Text:          "Hello",
+		FinishReason:  "STOP",
+		SafetyRatings: []SafetyRating{{Category: "HARM_CATEGORY_HARASSMENT", Probability: "NEGLIGIBLE"}},
+		Usage:         Usage{InputTokens: 3, OutputTokens: 1, TotalTokens: 4},
+		RequestID:     "abc123",
+	}
+
+	var buf bytes.Buffer
+	env := NewEnvelope("gemini", "gemini-2.5-flash", resp, 1500*time.Millisecond)
+	if err := writeOutput(&buf, "json", env); err != nil {
+		t.Fatalf("writeOutput failed: %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, buf.String())
+	}
+
+	expected := map[string]any{
+		"text":          "Hello",
+		"provider":      "gemini",
+		"model":         "gemini-2.5-flash",
+		"finish_reason": "STOP",
+		"latency_ms":    float64(1500),
+		"request_id":    "abc123",
+	}
+	for key, want := range expected {
+		if got[key] != want {
+			t.Errorf("Expected %s = %v, got %v", key, want, got[key])
+		}
+	}
+
+	usage, _ := got["usage"].(map[string]any)
+	if usage["input_tokens"] != float64(3) || usage["output_tokens"] != float64(1) || usage["total_tokens"] != float64(4) {
+		t.Errorf("Unexpected usage %v", got["usage"])
+	}
+	if ratings, _ := got["safety_ratings"].([]any); len(ratings) != 1 {
+		t.Errorf("Expected 1 safety rating, got %v", got["safety_ratings"])
+	}
+}
+
+func TestWriteOutputText(t *testing.T) {
+	var buf bytes.Buffer
+	env := NewEnvelope("ollama", "llama3", &Response{Text: "Plain", Model: "llama3:8b"}, 0)
+	if env.Model != "llama3:8b" {
+		t.Errorf("Expected reported model to win, got %q", env.Model)
+	}
+
+	if err := writeOutput(&buf, "text", env); err != nil {
+		t.Fatalf("writeOutput failed: %v", err)
+	}
+	if buf.String() != "Plain\n" {
+		t.Errorf("Expected plain text output, got %q", buf.String())
+	}
+
+	if err := writeOutput(&buf, "yaml", env); err == nil {
+		t.Error("Expected error for unknown format, got nil")
+	}
+}
+
+func TestEnumName(t *testing.T) {
+	tests := []struct {
+		name, prefix, expected string
+	}{
+		{"FinishReasonMaxTokens", "FinishReason", "MAX_TOKENS"},
+		{"FinishReasonStop", "FinishReason", "STOP"},
+		{"HarmCategoryDangerousContent", "HarmCategory", "DANGEROUS_CONTENT"},
+		{"HarmProbabilityNegligible", "HarmProbability", "NEGLIGIBLE"},
+	}
+
+	for _, tt := range tests {
+		if got := enumName(tt.name, tt.prefix); got != tt.expected {
+			t.Errorf("enumName(%q) = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestNewErrorEnvelope(t *testing.T) {
+	blocked := &ResponseError{
+		Kind:          ErrBlocked,
+		Provider:      "Gemini",
+		BlockReason:   "SAFETY",
+		SafetyRatings: []SafetyRating{{Category: "HARM_CATEGORY_HARASSMENT", Probability: "HIGH", Blocked: true}},
+	}
+	err := &modelError{provider: "gemini", model: "gemini-pro", err: fmt.Errorf("calling gemini API: %w", blocked)}
+
+	var buf bytes.Buffer
+	if err := writeOutput(&buf, "json", NewErrorEnvelope(err, blocked, 0)); err != nil {
+		t.Fatalf("writeOutput failed: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, buf.String())
+	}
+	expected := map[string]any{
+		"text":         "",
+		"provider":     "gemini",
+		"model":        "gemini-pro",
+		"block_reason": "SAFETY",
+		"error":        "calling gemini API: Gemini blocked the prompt (SAFETY): HARASSMENT HIGH",
+	}
+	for key, want := range expected {
+		if got[key] != want {
+			t.Errorf("Expected %s = %v, got %v", key, want, got[key])
+		}
+	}
+	if ratings, _ := got["safety_ratings"].([]any); len(ratings) != 1 {
+		t.Errorf("Expected 1 safety rating, got %v", got["safety_ratings"])
+	}
+}
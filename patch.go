@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const patchInstruction = `Return your changes as a single unified diff (as produced by "diff -u")
+against the attached files. Use the file paths exactly as given, with a/ and
+b/ prefixes, include at least 3 lines of unchanged context around each change,
+and output nothing but the diff.`
+
+// fileList is a repeatable string flag.
+type fileList []string
+
+func (f *fileList) String() string     { return strings.Join(*f, ",") }
+func (f *fileList) Set(v string) error { *f = append(*f, v); return nil }
+
+// attachFiles adds each file to req as a separate part, fenced and
+// labelled with its path.
+func attachFiles(req *Request, paths []string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("attaching file: %w", err)
+		}
+		fence := "```"
+		for strings.Contains(string(data), fence) {
+			fence += "`"
+		}
+		content := strings.TrimSuffix(string(data), "\n")
+		req.Parts = append(req.Parts, fmt.Sprintf("File: %s\n%s\n%s\n%s", path, fence, content, fence))
+	}
+	return nil
+}
+
+type FileDiff struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+// Hunk holds the body lines of a hunk, each starting with ' ', '-' or '+'.
+type Hunk struct {
+	OldStart int
+	Lines    []string
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// extractDiff returns the contents of the first ```diff fence in the
+// response, or the whole response if there is none.
+func extractDiff(text string) string {
+	for _, b := range parseCodeBlocks(text) {
+		if l := normalizeLang(b.Lang); l == "diff" || l == "patch" {
+			return b.Code
+		}
+	}
+	return text
+}
+
+// ParseUnifiedDiff parses a unified diff. Line counts in hunk headers are
+// ignored, since models often get them wrong; hunks end at the next header.
+func ParseUnifiedDiff(text string) ([]FileDiff, error) {
+	text = extractDiff(text)
+
+	var (
+		diffs []FileDiff
+		cur   *FileDiff
+		hunk  *Hunk
+	)
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			diffs = append(diffs, FileDiff{
+				OldPath: diffPath(line[4:]),
+				NewPath: diffPath(lines[i+1][4:]),
+			})
+			cur, hunk = &diffs[len(diffs)-1], nil
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("hunk before file header: %s", line)
+			}
+			m := hunkHeaderRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("invalid hunk header: %s", line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			cur.Hunks = append(cur.Hunks, Hunk{OldStart: start})
+			hunk = &cur.Hunks[len(cur.Hunks)-1]
+		case hunk == nil || strings.HasPrefix(line, `\`):
+			// Preamble ("diff --git", "index") or "\ No newline at end of file"
+		case line == "":
+			// Models often strip the leading space of blank context lines.
+			hunk.Lines = append(hunk.Lines, " ")
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.Lines = append(hunk.Lines, line)
+		default:
+			hunk = nil
+		}
+	}
+
+	for i := range diffs {
+		// Trailing blank lines are usually the end of the response, not context.
+		for j := range diffs[i].Hunks {
+			h := &diffs[i].Hunks[j]
+			for len(h.Lines) > 0 && h.Lines[len(h.Lines)-1] == " " {
+				h.Lines = h.Lines[:len(h.Lines)-1]
+			}
+		}
+	}
+
+	if len(diffs) == 0 {
+		return nil, fmt.Errorf("%w: no unified diff in response", errNoMatch)
+	}
+	return diffs, nil
+}
+
+func diffPath(s string) string {
+	// Strip timestamps ("--- a/x.go\t2024-01-01 ...") and the a/ b/ prefixes.
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s
+	}
+	for _, prefix := range []string{"a/", "b/"} {
+		if strings.HasPrefix(s, prefix) {
+			return filepath.Clean(s[len(prefix):])
+		}
+	}
+	return filepath.Clean(s)
+}
+
+// Apply returns content with the hunks applied. Each hunk must match
+// exactly, but may be found at an offset from its header line number.
+func (d *FileDiff) Apply(content string) (string, error) {
+	trailingNewline := strings.HasSuffix(content, "\n")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+
+	offset, floor := 0, 0
+	for n, h := range d.Hunks {
+		var old, replacement []string
+		for _, l := range h.Lines {
+			if l[0] != '+' {
+				old = append(old, l[1:])
+			}
+			if l[0] != '-' {
+				replacement = append(replacement, l[1:])
+			}
+		}
+
+		pos := findLines(lines, old, h.OldStart-1+offset, floor)
+		if pos < 0 {
+			return "", fmt.Errorf("%s: hunk %d does not apply", d.NewPath, n+1)
+		}
+
+		lines = append(lines[:pos], append(replacement, lines[pos+len(old):]...)...)
+		offset = pos - (h.OldStart - 1) + len(replacement) - len(old)
+		floor = pos + len(replacement)
+	}
+
+	out := strings.Join(lines, "\n")
+	if trailingNewline || content == "" {
+		out += "\n"
+	}
+	return out, nil
+}
+
+// findLines returns the index of want in lines closest to near, not
+// before floor, or -1. A near past the end, from a hunk header beyond
+// the file, searches from the end.
+func findLines(lines, want []string, near, floor int) int {
+	near = max(floor, min(near, len(lines)))
+	matches := func(pos int) bool {
+		if pos < floor || pos+len(want) > len(lines) {
+			return false
+		}
+		for i, l := range want {
+			if lines[pos+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+
+	for d := 0; d <= len(lines); d++ {
+		if matches(near - d) {
+			return near - d
+		}
+		if matches(near + d) {
+			return near + d
+		}
+	}
+	return -1
+}
+
+// PatchResult is a validated diff for one attached file.
+type PatchResult struct {
+	Path     string
+	Original string
+	Patched  string
+}
+
+// PreparePatch parses the model's diff and applies it in memory to the
+// attached files. Diffs touching any other path are rejected.
+func PreparePatch(response string, files []string) ([]PatchResult, error) {
+	diffs, err := ParseUnifiedDiff(response)
+	if err != nil {
+		return nil, err
+	}
+
+	attached := make(map[string]string)
+	for _, f := range files {
+		attached[filepath.Clean(f)] = f
+	}
+
+	var results []PatchResult
+	index := make(map[string]int)
+	for _, d := range diffs {
+		path, ok := attached[d.NewPath]
+		if !ok || d.OldPath != d.NewPath {
+			return nil, fmt.Errorf("diff modifies %s, which is not an attached file", d.NewPath)
+		}
+
+		// Several sections for the same file apply on top of each other.
+		i, seen := index[path]
+		if !seen {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			i = len(results)
+			index[path] = i
+			results = append(results, PatchResult{Path: path, Original: string(data), Patched: string(data)})
+		}
+
+		patched, err := d.Apply(results[i].Patched)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Patched = patched
+	}
+	return results, nil
+}
+
+// ApplyPatch writes the patched files, first saving the originals as
+// .orig files when backup is set. Every file is checked and its new
+// content written to a temporary file before any is replaced, so a
+// failure leaves the tree as it was.
+func ApplyPatch(w io.Writer, results []PatchResult, backup bool) error {
+	temps := make([]string, 0, len(results))
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+	for _, r := range results {
+		data, err := os.ReadFile(r.Path)
+		if err != nil {
+			return err
+		}
+		if string(data) != r.Original {
+			return fmt.Errorf("%s changed since the patch was made", r.Path)
+		}
+		tmp, err := writeTemp(r.Path, r.Patched)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, tmp)
+	}
+
+	for i, r := range results {
+		if backup {
+			info, err := os.Stat(r.Path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(r.Path+".orig", []byte(r.Original), info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(temps[i], r.Path); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "patched %s\n", r.Path)
+	}
+	return nil
+}
+
+// writeTemp writes content to a new file next to path with path's
+// permissions and returns its name.
+func writeTemp(path, content string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.WriteString(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// colorDiff styles diff lines for a terminal.
+func colorDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for i, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "+++") || strings.HasPrefix(l, "---"):
+			lines[i] = ansiBold + l + ansiReset
+		case strings.HasPrefix(l, "@@"):
+			lines[i] = ansiCyan + l + ansiReset
+		case strings.HasPrefix(l, "+"):
+			lines[i] = ansiGreen + l + ansiReset
+		case strings.HasPrefix(l, "-"):
+			lines[i] = ansiRed + l + ansiReset
+		}
+	}
+	return strings.Join(lines, "\n")
+}
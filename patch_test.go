@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const originalSource = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+
+func helper() int {
+	return 1
+}
+`
+
+func TestFileDiffApply(t *testing.T) {
+	// The second hunk header is off by two lines, as models often are.
+	diff := "Here you go:\n\n```diff\n" +
+		"--- a/main.go\n+++ b/main.go\n" +
+		"@@ -5,3 +5,3 @@\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"hello, world\")\n }\n" +
+		"@@ -11,3 +11,4 @@\n func helper() int {\n-\treturn 1\n+\t// Always two.\n+\treturn 2\n }\n" +
+		"```\n"
+
+	diffs, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].NewPath != "main.go" || len(diffs[0].Hunks) != 2 {
+		t.Fatalf("Unexpected diffs: %+v", diffs)
+	}
+
+	got, err := diffs[0].Apply(originalSource)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	expected := strings.Replace(originalSource, `"hello"`, `"hello, world"`, 1)
+	expected = strings.Replace(expected, "\treturn 1\n", "\t// Always two.\n\treturn 2\n", 1)
+	if got != expected {
+		t.Errorf("Unexpected result:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestFileDiffApplyConflict(t *testing.T) {
+	diffs, err := ParseUnifiedDiff("--- a/main.go\n+++ b/main.go\n@@ -5,2 +5,2 @@\n func main() {\n-\tfmt.Println(\"bye\")\n+\tfmt.Println(\"hi\")\n")
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+
+	if _, err := diffs[0].Apply(originalSource); err == nil || !strings.Contains(err.Error(), "hunk 1 does not apply") {
+		t.Errorf("Expected hunk conflict error, got %v", err)
+	}
+}
+
+func TestFileDiffApplyHeaderPastEnd(t *testing.T) {
+	diffs, err := ParseUnifiedDiff("--- a/main.go\n+++ b/main.go\n@@ -50,3 +50,3 @@\n func helper() int {\n-\treturn 1\n+\treturn 2\n }\n")
+	if err != nil {
+		t.Fatalf("ParseUnifiedDiff failed: %v", err)
+	}
+	got, err := diffs[0].Apply(originalSource)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got != strings.Replace(originalSource, "return 1", "return 2", 1) {
+		t.Errorf("Unexpected result:\n%s", got)
+	}
+}
+
+func TestParseUnifiedDiffNone(t *testing.T) {
+	if _, err := ParseUnifiedDiff("I would rename the variable."); !errors.Is(err, errNoMatch) {
+		t.Errorf("Expected errNoMatch, got %v", err)
+	}
+}
+
+func TestPreparePatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte(originalSource), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	diff := "--- a/" + path + "\n+++ b/" + path + "\n@@ -1,3 +1,3 @@\n-package main\n+package app\n \n import \"fmt\"\n"
+
+	results, err := PreparePatch(diff, []string{path})
+	if err != nil {
+		t.Fatalf("PreparePatch failed: %v", err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(results[0].Patched, "package app\n") {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+
+	// Validation alone leaves the file untouched.
+	if data, _ := os.ReadFile(path); string(data) != originalSource {
+		t.Error("File changed before ApplyPatch")
+	}
+
+	var out bytes.Buffer
+	if err := ApplyPatch(&out, results, true); err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), "package app\n") {
+		t.Errorf("Patch not applied:\n%s", data)
+	}
+	if data, _ := os.ReadFile(path + ".orig"); string(data) != originalSource {
+		t.Errorf("Backup does not hold the original:\n%s", data)
+	}
+	if out.String() != "patched "+path+"\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestApplyPatchAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	os.WriteFile(first, []byte("package a\n"), 0o644)
+	os.WriteFile(second, []byte("package b\n"), 0o644)
+	results := []PatchResult{
+		{Path: first, Original: "package a\n", Patched: "package x\n"},
+		{Path: second, Original: "package b\n", Patched: "package y\n"},
+	}
+
+	// The second file changed after the patch was made.
+	os.WriteFile(second, []byte("package c\n"), 0o644)
+	var out bytes.Buffer
+	if err := ApplyPatch(&out, results, false); err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Errorf("Expected an error for the changed file, got %v", err)
+	}
+	if data, _ := os.ReadFile(first); string(data) != "package a\n" {
+		t.Errorf("Expected the first file to be untouched, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected no temporary files to remain, got %v", entries)
+	}
+}
+
+func TestPreparePatchRejectsOtherFiles(t *testing.T) {
+	diff := "--- a/etc/passwd\n+++ b/etc/passwd\n@@ -1 +1 @@\n-root\n+toor\n"
+
+	_, err := PreparePatch(diff, []string{"main.go"})
+	if err == nil || !strings.Contains(err.Error(), "not an attached file") {
+		t.Errorf("Expected rejection of unattached file, got %v", err)
+	}
+}
+
+func TestAttachFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "README.md")
+	os.WriteFile(path, []byte("Use ```code``` blocks\n"), 0o644)
+
+	req := NewRequest(&Prompt{Prompt: "Review"}, "")
+	if err := attachFiles(req, []string{path}); err != nil {
+		t.Fatalf("attachFiles failed: %v", err)
+	}
+
+	expected := "File: " + path + "\n````\nUse ```code``` blocks\n````"
+	if len(req.Parts) != 2 || req.Parts[1] != expected {
+		t.Errorf("Unexpected parts: %q", req.Parts)
+	}
+
+	if err := attachFiles(req, []string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Expected error for missing file, got nil")
+	}
+}
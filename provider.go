@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Provider sends requests to a model backend.
+type Provider interface {
+	Generate(ctx context.Context, req *Request) (*Response, error)
+}
+
+// Streamer is a Provider that can pass on the text of a response as it
+// is generated. GenerateStream calls onText with each piece of text and
+// returns the whole response, like Generate.
+type Streamer interface {
+	GenerateStream(ctx context.Context, req *Request, onText func(string)) (*Response, error)
+}
+
+// Request is a provider-neutral generation request for a single user
+// turn, optionally following earlier turns of a conversation. The turn
+// is made of text parts and the results of tool calls the model asked
+// for in the previous turn.
+type Request struct {
+	System  string
+	History []Message
+	Parts   []string
+	Results []ToolResult
+	Params  Params
+	Safety  Safety
+	Tools   []ToolSpec
+}
+
+// Message is one earlier turn of a conversation. Role is "user" or "model".
+type Message struct {
+	Role    string       `json:"role"`
+	Parts   []string     `json:"parts"`
+	Calls   []ToolCall   `json:"calls,omitempty"`
+	Results []ToolResult `json:"results,omitempty"`
+}
+
+// turn returns the user message req sends.
+func (r *Request) turn() Message {
+	return Message{Role: "user", Parts: r.Parts, Results: r.Results}
+}
+
+type Response struct {
+	Text          string
+	Model         string
+	FinishReason  string
+	SafetyRatings []SafetyRating
+	Usage         Usage
+	RequestID     string
+	ToolCalls     []ToolCall
+	OtherParts    []string // kinds of non-text parts that were dropped
+}
+
+// turn returns the model message for r.
+func (r *Response) turn() Message {
+	m := Message{Role: "model", Calls: r.ToolCalls}
+	if r.Text != "" || len(r.ToolCalls) == 0 {
+		m.Parts = []string{r.Text}
+	}
+	return m
+}
+
+// SafetyRating uses the Gemini REST enum names, e.g. category
+// HARM_CATEGORY_HARASSMENT with probability NEGLIGIBLE.
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// Add returns the sum of u and o.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + o.InputTokens,
+		OutputTokens: u.OutputTokens + o.OutputTokens,
+		CachedTokens: u.CachedTokens + o.CachedTokens,
+		TotalTokens:  u.TotalTokens + o.TotalTokens,
+	}
+}
+
+// APIError is an error status returned by a provider's HTTP API.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Provider + ": " + e.Message
+}
+
+func NewRequest(p *Prompt, input string) *Request {
+	text := p.Prompt
+	if p.Prompt == "" {
+		text = input
+	} else if input != "" {
+		text = p.Prompt + "\n\n" + input
+	}
+	return &Request{
+		System: p.System,
+		Parts:  []string{text},
+		Params: p.Params,
+		Safety: p.Safety,
+	}
+}
+
+var defaultModels = map[string]string{
+	"gemini": "gemini-pro",
+	"vertex": "gemini-1.5-flash",
+	"ollama": "llama3",
+}
+
+func providerName(cfg *Config) string {
+	name := strings.ToLower(strings.TrimSpace(cfg.Provider))
+	if name == "" {
+		return "gemini"
+	}
+	return name
+}
+
+// providerConfig returns the settings of cfg that apply to the named
+// provider. The endpoint belongs to the configured provider; others,
+// selected by a model prefix, take theirs from endpoints.
+func providerConfig(cfg *Config, name string) *Config {
+	if name == providerName(cfg) && (cfg.Endpoint != "" || cfg.Endpoints[name] == "") {
+		return cfg
+	}
+	other := *cfg
+	other.Provider, other.Endpoint = name, cfg.Endpoints[name]
+	return &other
+}
+
+// NewProvider creates a client for the provider selected in cfg, or by
+// a provider prefix in modelName such as "local:llama3". An empty
+// modelName selects the provider's default model.
+func NewProvider(cfg *Config, modelName string) (Provider, error) {
+	name, modelName := splitModel(cfg, modelName)
+	cfg = providerConfig(cfg, name)
+	if modelName == "" {
+		modelName = defaultModels[name]
+	}
+
+	switch name {
+	case "gemini":
+		apiKey, err := cfg.ResolveAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(apiKey, modelName)
+	case "vertex":
+		return NewVertexClient(cfg.Project, cfg.Location, cfg.Endpoint, modelName)
+	case "ollama":
+		return NewOllamaClient(cfg.Endpoint, modelName), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+}
+
+// MarshalRequest returns the provider-native JSON body that would be
+// sent for req. It does not need credentials.
+func MarshalRequest(provider, modelName string, req *Request) ([]byte, error) {
+	switch provider {
+	case "gemini", "vertex":
+		return json.MarshalIndent(newRestRequest(req), "", "  ")
+	case "ollama":
+		return json.MarshalIndent(newOllamaRequest(modelName, req), "", "  ")
+	}
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiDim       = "\x1b[2m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiRed       = "\x1b[31m"
+	ansiGreen     = "\x1b[32m"
+	ansiYellow    = "\x1b[33m"
+	ansiBlue      = "\x1b[34m"
+	ansiMagenta   = "\x1b[35m"
+	ansiCyan      = "\x1b[36m"
+)
+
+// shouldRender decides whether to render markdown for --render=mode.
+func shouldRender(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		stat, err := os.Stdout.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return stat.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("unknown render mode %q (want auto, always or never)", mode)
+}
+
+// terminalWidth returns the width of the terminal on stdout, $COLUMNS or 80.
+func terminalWidth() int {
+	if w := ttyWidth(os.Stdout); w > 0 {
+		return w
+	}
+	var w int
+	if _, err := fmt.Sscan(os.Getenv("COLUMNS"), &w); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+var (
+	headingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	listRe     = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	ruleRe     = regexp.MustCompile(`^\s{0,3}((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+	tableSepRe = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+	inlineCodeRe = regexp.MustCompile("`([^`]+)`")
+	boldRe       = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	italicRe     = regexp.MustCompile(`(^|[^\w*])\*([^*\s][^*]*)\*|(^|[^\w_])_([^_\s][^_]*)_`)
+	linkRe       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	ansiRe       = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
+
+// RenderMarkdown renders model markdown for a terminal of the given
+// width using ANSI styles. It handles the subset models commonly emit:
+// headings, paragraphs, lists, block quotes, rules, tables and fenced
+// code, plus bold, italic, inline code and links.
+func RenderMarkdown(text string, width int) string {
+	r := &renderer{width: width}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if f, lang := openingFence(strings.TrimLeft(line, " ")); f != "" {
+			var code []string
+			for i++; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if strings.HasPrefix(t, f) && strings.Trim(t, f[:1]) == "" {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			r.flush()
+			r.code(lang, code)
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			r.flush()
+			r.blank()
+		case headingRe.MatchString(trimmed):
+			r.flush()
+			m := headingRe.FindStringSubmatch(trimmed)
+			r.heading(len(m[1]), m[2])
+		case ruleRe.MatchString(line):
+			r.flush()
+			r.emit(ansiDim + strings.Repeat("─", r.width) + ansiReset)
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableSepRe.MatchString(lines[i+1]):
+			r.flush()
+			rows := [][]string{splitRow(trimmed)}
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, splitRow(strings.TrimSpace(lines[i])))
+			}
+			i--
+			r.table(rows)
+		case listRe.MatchString(line):
+			r.flush()
+			m := listRe.FindStringSubmatch(line)
+			r.listItem(len(m[1]), m[2], m[3])
+		case strings.HasPrefix(trimmed, ">"):
+			r.flush()
+			quote := strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+			r.wrap(inline(quote), ansiDim+"│ "+ansiReset, ansiDim+"│ "+ansiReset)
+		default:
+			r.para = append(r.para, trimmed)
+		}
+	}
+	r.flush()
+
+	return strings.TrimRight(strings.Join(r.out, "\n"), "\n")
+}
+
+type renderer struct {
+	width int
+	out   []string
+	para  []string
+}
+
+func (r *renderer) emit(line string) {
+	r.out = append(r.out, line)
+}
+
+func (r *renderer) blank() {
+	if len(r.out) > 0 && r.out[len(r.out)-1] != "" {
+		r.emit("")
+	}
+}
+
+func (r *renderer) flush() {
+	if len(r.para) == 0 {
+		return
+	}
+	r.wrap(inline(strings.Join(r.para, " ")), "", "")
+	r.para = nil
+}
+
+func (r *renderer) heading(level int, text string) {
+	r.blank()
+	switch level {
+	case 1:
+		r.emit(ansiBold + ansiUnderline + ansiMagenta + text + ansiReset)
+	case 2:
+		r.emit(ansiBold + ansiMagenta + text + ansiReset)
+	default:
+		r.emit(ansiBold + text + ansiReset)
+	}
+}
+
+func (r *renderer) listItem(indent int, marker, text string) {
+	if marker == "-" || marker == "*" || marker == "+" {
+		marker = "•"
+	}
+	pad := strings.Repeat(" ", indent)
+	first := pad + ansiCyan + marker + ansiReset + " "
+	rest := pad + strings.Repeat(" ", utf8.RuneCountInString(marker)+1)
+	r.wrap(inline(text), first, rest)
+}
+
+// wrap word-wraps styled text to the renderer width, starting the first
+// line with first and continuation lines with rest.
+func (r *renderer) wrap(text, first, rest string) {
+	prefix := first
+	line := prefix
+	lineLen := visibleLen(prefix)
+	empty := true
+
+	for _, word := range strings.Fields(text) {
+		wl := visibleLen(word)
+		if !empty && lineLen+1+wl > r.width {
+			r.emit(line)
+			prefix = rest
+			line, lineLen, empty = prefix, visibleLen(prefix), true
+		}
+		if !empty {
+			line += " "
+			lineLen++
+		}
+		line += word
+		lineLen += wl
+		empty = false
+	}
+	if !empty {
+		r.emit(line)
+	}
+}
+
+func (r *renderer) code(lang string, lines []string) {
+	r.blank()
+	if lang != "" {
+		r.emit(ansiDim + "  " + lang + ansiReset)
+	}
+	hl := newHighlighter(lang)
+	for _, l := range lines {
+		r.emit("  " + hl.line(strings.ReplaceAll(l, "\t", "    ")))
+	}
+	r.emit("")
+}
+
+func (r *renderer) table(rows [][]string) {
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	widths := make([]int, cols)
+	styled := make([][]string, len(rows))
+	for i, row := range rows {
+		styled[i] = make([]string, cols)
+		for j := 0; j < cols; j++ {
+			if j < len(row) {
+				styled[i][j] = inline(row[j])
+			}
+			widths[j] = max(widths[j], visibleLen(styled[i][j]))
+		}
+	}
+
+	r.blank()
+	for i, row := range styled {
+		var cells []string
+		for j, cell := range row {
+			pad := strings.Repeat(" ", widths[j]-visibleLen(cell))
+			if i == 0 {
+				cell = ansiBold + cell + ansiReset
+			}
+			cells = append(cells, cell+pad)
+		}
+		r.emit(strings.Join(cells, ansiDim+" │ "+ansiReset))
+		if i == 0 {
+			var sep []string
+			for _, w := range widths {
+				sep = append(sep, strings.Repeat("─", w))
+			}
+			r.emit(ansiDim + strings.Join(sep, "─┼─") + ansiReset)
+		}
+	}
+	r.emit("")
+}
+
+func splitRow(line string) []string {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// inline applies styles for inline code, links, bold and italic. Code
+// spans are styled first and protected from the other rules.
+func inline(text string) string {
+	var spans []string
+	text = inlineCodeRe.ReplaceAllStringFunc(text, func(m string) string {
+		spans = append(spans, ansiYellow+m[1:len(m)-1]+ansiReset)
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+
+	text = linkRe.ReplaceAllString(text, ansiUnderline+"$1"+ansiReset+" "+ansiDim+"($2)"+ansiReset)
+	text = boldRe.ReplaceAllString(text, ansiBold+"$1$2"+ansiReset)
+	text = italicRe.ReplaceAllString(text, "$1$3"+ansiItalic+"$2$4"+ansiReset)
+
+	for i, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), span, 1)
+	}
+	return text
+}
+
+func visibleLen(s string) int {
+	return utf8.RuneCountInString(ansiRe.ReplaceAllString(s, ""))
+}
+
+var codeKeywords = map[string][]string{
+	"go":         {"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var", "nil", "true", "false"},
+	"python":     {"and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del", "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is", "lambda", "None", "nonlocal", "not", "or", "pass", "raise", "return", "True", "False", "try", "while", "with", "yield"},
+	"javascript": {"async", "await", "break", "case", "catch", "class", "const", "continue", "default", "delete", "do", "else", "export", "extends", "finally", "for", "function", "if", "import", "in", "instanceof", "let", "new", "null", "of", "return", "switch", "this", "throw", "true", "false", "try", "typeof", "undefined", "var", "while", "yield"},
+	"bash":       {"case", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function", "if", "in", "local", "return", "then", "until", "while"},
+	"rust":       {"as", "async", "await", "break", "const", "continue", "crate", "else", "enum", "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move", "mut", "pub", "ref", "return", "self", "Self", "static", "struct", "trait", "true", "false", "type", "unsafe", "use", "where", "while"},
+	"cpp":        {"auto", "break", "case", "class", "const", "continue", "default", "delete", "do", "else", "enum", "for", "if", "include", "namespace", "new", "nullptr", "private", "protected", "public", "return", "static", "struct", "switch", "template", "this", "true", "false", "typedef", "using", "virtual", "void", "while"},
+	"sql":        {"select", "from", "where", "and", "or", "not", "insert", "into", "values", "update", "set", "delete", "create", "table", "join", "left", "right", "inner", "on", "group", "by", "order", "limit", "as", "null"},
+}
+
+func init() {
+	codeKeywords["typescript"] = append(codeKeywords["javascript"], "interface", "type", "enum", "implements", "private", "public", "readonly")
+	codeKeywords["java"] = codeKeywords["cpp"]
+	codeKeywords["c"] = codeKeywords["cpp"]
+}
+
+// highlighter is a small lexical highlighter: comments, strings,
+// numbers and the keywords of common languages.
+type highlighter struct {
+	keywords   map[string]bool
+	comment    string
+	ignoreCase bool
+}
+
+func newHighlighter(lang string) *highlighter {
+	lang = normalizeLang(lang)
+	h := &highlighter{keywords: make(map[string]bool), comment: "//", ignoreCase: lang == "sql"}
+	for _, kw := range codeKeywords[lang] {
+		h.keywords[kw] = true
+	}
+	switch lang {
+	case "python", "bash", "yaml", "ruby", "toml", "docker":
+		h.comment = "#"
+	case "sql":
+		h.comment = "--"
+	}
+	return h
+}
+
+func (h *highlighter) line(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], h.comment):
+			b.WriteString(ansiDim + s[i:] + ansiReset)
+			return b.String()
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(s))
+			b.WriteString(ansiGreen + s[i:j] + ansiReset)
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(s) && (isIdentStart(s[j]) || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			word := s[i:j]
+			if h.keywords[word] || h.ignoreCase && h.keywords[strings.ToLower(word)] {
+				b.WriteString(ansiBlue + word + ansiReset)
+			} else {
+				b.WriteString(word)
+			}
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'x' || s[j] >= 'a' && s[j] <= 'f') {
+				j++
+			}
+			b.WriteString(ansiRed + s[i:j] + ansiReset)
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownLayout(t *testing.T) {
+	input := "# Title\n\n" +
+		"A **bold** paragraph with `code` and a [link](https://example.com) that wraps at the width.\n\n" +
+		"- item one that is long enough to wrap\n" +
+		"> quoted\n\n" +
+		"| Name | Value |\n|---|---|\n| a | 1 |\n| long | 22 |\n\n" +
+		"```go\nfunc main() {}\n```\n" +
+		"***\n"
+
+	expected := strings.Join([]string{
+		"Title",
+		"",
+		"A bold paragraph with code and a link",
+		"(https://example.com) that wraps at the",
+		"width.",
+		"",
+		"• item one that is long enough to wrap",
+		"│ quoted",
+		"",
+		"Name │ Value",
+		"─────┼──────",
+		"a    │ 1    ",
+		"long │ 22   ",
+		"",
+		"  go",
+		"  func main() {}",
+		"",
+		strings.Repeat("─", 40),
+	}, "\n")
+
+	got := stripANSI(RenderMarkdown(input, 40))
+	if got != expected {
+		t.Errorf("Unexpected rendering:\n%s\n\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestRenderMarkdownStyles(t *testing.T) {
+	got := RenderMarkdown("## Heading\n\nSome **bold**, *italic* and `x*y*z`.\n\n```go\nreturn \"s\" // done\n```", 80)
+
+	for _, want := range []string{
+		ansiBold + ansiMagenta + "Heading" + ansiReset,
+		ansiBold + "bold" + ansiReset,
+		ansiItalic + "italic" + ansiReset,
+		ansiYellow + "x*y*z" + ansiReset,
+		ansiBlue + "return" + ansiReset,
+		ansiGreen + `"s"` + ansiReset,
+		ansiDim + "// done" + ansiReset,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected rendering to contain %q, got:\n%q", want, got)
+		}
+	}
+}
+
+func TestRenderMarkdownInlineFence(t *testing.T) {
+	got := stripANSI(RenderMarkdown("```foo``` bar\n\n# Next", 40))
+	if !strings.HasSuffix(got, "foo`` bar\n\nNext") {
+		t.Errorf("Expected inline code, not a code block, got:\n%s", got)
+	}
+}
+
+func TestShouldRender(t *testing.T) {
+	if ok, _ := shouldRender("always"); !ok {
+		t.Error("Expected always to render")
+	}
+	if ok, _ := shouldRender("never"); ok {
+		t.Error("Expected never not to render")
+	}
+	// Test output is not a terminal
+	if ok, _ := shouldRender("auto"); ok {
+		t.Error("Expected auto not to render when stdout is not a terminal")
+	}
+	if _, err := shouldRender("sometimes"); err == nil {
+		t.Error("Expected error for unknown mode, got nil")
+	}
+}
+
+// stripANSI removes ANSI style sequences.
+func stripANSI(s string) string {
+	return ansiRe.ReplaceAllString(s, "")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Safety maps provider-neutral harm category names to block thresholds,
+// e.g. dangerous_content: only_high.
+type Safety map[string]string
+
+var safetyCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+var safetyThresholds = map[string]genai.HarmBlockThreshold{
+	"none":             genai.HarmBlockNone,
+	"only_high":        genai.HarmBlockOnlyHigh,
+	"medium_and_above": genai.HarmBlockMediumAndAbove,
+	"low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+func (s Safety) validate() error {
+	var errs []error
+	for _, category := range s.categories() {
+		if _, ok := safetyCategories[category]; !ok {
+			errs = append(errs, fmt.Errorf("unknown safety category %q (want harassment, hate_speech, sexually_explicit or dangerous_content)", category))
+		}
+		if _, ok := safetyThresholds[s[category]]; !ok {
+			errs = append(errs, fmt.Errorf("unknown safety threshold %q for %s (want none, only_high, medium_and_above or low_and_above)", s[category], category))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Merge returns s with categories it does not set taken from defaults.
+func (s Safety) Merge(defaults Safety) Safety {
+	if len(defaults) == 0 {
+		return s
+	}
+	out := make(Safety, len(s)+len(defaults))
+	for k, v := range defaults {
+		out[k] = v
+	}
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
+
+func (s Safety) categories() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String lists the settings as "category=threshold" pairs.
+func (s Safety) String() string {
+	pairs := make([]string, 0, len(s))
+	for _, category := range s.categories() {
+		pairs = append(pairs, category+"="+s[category])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// genaiSettings converts s for the Gemini client, skipping unknown names.
+func (s Safety) genaiSettings() []*genai.SafetySetting {
+	var out []*genai.SafetySetting
+	for _, category := range s.categories() {
+		c, ok := safetyCategories[category]
+		t, ok2 := safetyThresholds[s[category]]
+		if ok && ok2 {
+			out = append(out, &genai.SafetySetting{Category: c, Threshold: t})
+		}
+	}
+	return out
+}
+
+// restSettings converts s to the Gemini REST names, as used by Vertex.
+func (s Safety) restSettings() []restSafetySetting {
+	var out []restSafetySetting
+	for _, setting := range s.genaiSettings() {
+		out = append(out, restSafetySetting{
+			Category:  "HARM_CATEGORY_" + enumName(setting.Category.String(), "HarmCategory"),
+			Threshold: "BLOCK_" + enumName(setting.Threshold.String(), "HarmBlock"),
+		})
+	}
+	return out
+}
+
+// warnUnsupportedSafety warns that provider has no safety settings to
+// apply s to.
+func warnUnsupportedSafety(provider string, s Safety) {
+	if provider == "ollama" && len(s) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s does not support safety settings, ignoring them\n", providerTitle(provider))
+	}
+}
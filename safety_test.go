@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSafetyConfig(t *testing.T) {
+	data := `
+safety:
+  harassment: only_high
+  dangerous_content: medium_and_above
+prompts:
+  - name: triage
+    prompt: Triage this crash report
+    safety:
+      dangerous_content: none
+`
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	p := cfg.ResolvePrompt("triage")
+	expected := Safety{"harassment": "only_high", "dangerous_content": "none"}
+	if p.Safety.String() != expected.String() {
+		t.Errorf("Expected merged safety %q, got %q", expected, p.Safety)
+	}
+	if req := NewRequest(p, "input"); req.Safety.String() != expected.String() {
+		t.Errorf("Expected request safety %q, got %q", expected, req.Safety)
+	}
+	if len(cfg.Prompts[0].Safety) != 1 {
+		t.Errorf("Expected ResolvePrompt to leave the config unchanged, got %v", cfg.Prompts[0].Safety)
+	}
+}
+
+func TestSafetyValidate(t *testing.T) {
+	cfg := &Config{
+		Safety:  Safety{"violence": "none"},
+		Prompts: []Prompt{{Name: "a", Prompt: "A", Safety: Safety{"harassment": "block_all"}}},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors, got nil")
+	}
+	for _, want := range []string{
+		`unknown safety category "violence"`,
+		`prompt "a": unknown safety threshold "block_all" for harassment`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestSafetyGenaiSettings(t *testing.T) {
+	s := Safety{"sexually_explicit": "low_and_above", "hate_speech": "none"}
+	got := s.genaiSettings()
+	expected := []genai.SafetySetting{
+		{Category: genai.HarmCategoryHateSpeech, Threshold: genai.HarmBlockNone},
+		{Category: genai.HarmCategorySexuallyExplicit, Threshold: genai.HarmBlockLowAndAbove},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d settings, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if *got[i] != expected[i] {
+			t.Errorf("Setting %d: expected %+v, got %+v", i, expected[i], *got[i])
+		}
+	}
+}
+
+func TestNewRestRequestSafety(t *testing.T) {
+	req := &Request{Parts: []string{"hi"}, Safety: Safety{"dangerous_content": "only_high", "harassment": "medium_and_above"}}
+	data, err := json.Marshal(newRestRequest(req))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := `"safetySettings":[{"category":"HARM_CATEGORY_DANGEROUS_CONTENT","threshold":"BLOCK_ONLY_HIGH"},{"category":"HARM_CATEGORY_HARASSMENT","threshold":"BLOCK_MEDIUM_AND_ABOVE"}]`
+	if !strings.Contains(string(data), expected) {
+		t.Errorf("Expected %s in request, got %s", expected, data)
+	}
+
+	data, _ = json.Marshal(newRestRequest(&Request{Parts: []string{"hi"}}))
+	if strings.Contains(string(data), "safetySettings") {
+		t.Errorf("Expected no safetySettings without settings, got %s", data)
+	}
+}
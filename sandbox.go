@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sandbox limits what tool commands can do. ReadOnly and Isolate need
+// Linux with unprivileged user namespaces.
+type Sandbox struct {
+	Timeout     string   `yaml:"timeout"`       // default 30s
+	MaxOutputKB int      `yaml:"max_output_kb"` // default 64
+	Env         []string `yaml:"env"`           // NAME to pass through or NAME=value; replaces the defaults
+	Dir         string   `yaml:"dir"`           // working directory, default the current one
+	ReadOnly    bool     `yaml:"read_only"`     // mount the working directory read-only
+	Isolate     bool     `yaml:"isolate"`       // separate namespaces without network, and a seccomp filter
+	AuditLog    string   `yaml:"audit_log"`     // default $XDG_STATE_HOME/pipellm/tools.jsonl
+}
+
+const (
+	defaultToolTimeout   = 30 * time.Second
+	defaultToolOutputKB  = 64
+	maxToolStderr        = 4 << 10
+	toolOutputTruncation = "\n[output truncated at %d KB]\n"
+)
+
+// defaultToolEnv are the variables tool commands see unless the sandbox
+// lists its own. Everything else, such as API keys, is dropped.
+var defaultToolEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "LC_CTYPE", "TZ", "TMPDIR", "TERM"}
+
+func (s *Sandbox) validate() error {
+	var errs []error
+	if s.Timeout != "" {
+		if d, err := time.ParseDuration(s.Timeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("sandbox: invalid timeout %q", s.Timeout))
+		}
+	}
+	if s.MaxOutputKB < 0 {
+		errs = append(errs, fmt.Errorf("sandbox: max_output_kb must not be negative"))
+	}
+	for _, e := range s.Env {
+		name, _, _ := strings.Cut(e, "=")
+		if name == "" {
+			errs = append(errs, fmt.Errorf("sandbox: invalid env entry %q", e))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sandbox) timeout() time.Duration {
+	if d, err := time.ParseDuration(s.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultToolTimeout
+}
+
+// environ returns the scrubbed environment for tool commands.
+func (s *Sandbox) environ() []string {
+	names := s.Env
+	if names == nil {
+		names = defaultToolEnv
+	}
+	var env []string
+	for _, name := range names {
+		if strings.Contains(name, "=") {
+			env = append(env, name)
+		} else if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// dir returns the absolute working directory for tool commands.
+func (s *Sandbox) dir() (string, error) {
+	if s.Dir == "" {
+		return os.Getwd()
+	}
+	return filepath.Abs(s.Dir)
+}
+
+// outputLimit returns the size limit of tool output in KB.
+func (s *Sandbox) outputLimit() int {
+	if s.MaxOutputKB == 0 {
+		return defaultToolOutputKB
+	}
+	return s.MaxOutputKB
+}
+
+// truncate cuts out off at the size limit, as Run does.
+func (s *Sandbox) truncate(out string) string {
+	if limit := s.outputLimit(); len(out) > limit<<10 {
+		return out[:limit<<10] + fmt.Sprintf(toolOutputTruncation, limit)
+	}
+	return out
+}
+
+// serverCommand returns the command that starts a tool server, such as
+// an MCP server, with the scrubbed environment. With ReadOnly or Isolate
+// it runs in the sandbox like tool commands, in the sandbox directory.
+func (s *Sandbox) serverCommand(name string, args []string) (*exec.Cmd, error) {
+	if !s.ReadOnly && !s.Isolate {
+		cmd := exec.Command(name, args...)
+		cmd.Env = s.environ()
+		return cmd, nil
+	}
+	dir, err := s.dir()
+	if err != nil {
+		return nil, err
+	}
+	words := []string{"exec", shQuote(name)}
+	for _, arg := range args {
+		words = append(words, shQuote(arg))
+	}
+	// The server lives until it is closed, not as long as a context.
+	cmd, err := s.command(context.Background(), dir, strings.Join(words, " "))
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = dir
+	cmd.Env = s.environ()
+	return cmd, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Run runs the tool's command in the sandbox with args on stdin and
+// returns its output, cut off at the size limit.
+func (s *Sandbox) Run(ctx context.Context, t Tool, args []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	dir, err := s.dir()
+	if err != nil {
+		return "", err
+	}
+	cmd, err := s.command(ctx, dir, t.Command)
+	if err != nil {
+		return "", err
+	}
+	cmd.Dir = dir
+	cmd.Env = s.environ()
+	cmd.Stdin = bytes.NewReader(args)
+	// Background processes could keep the output pipes open.
+	cmd.WaitDelay = time.Second
+
+	stdout := &limitedBuffer{max: s.outputLimit() << 10}
+	stderr := &limitedBuffer{max: maxToolStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err = cmd.Run()
+	out := stdout.buf.String()
+	if stdout.truncated {
+		out += fmt.Sprintf(toolOutputTruncation, s.outputLimit())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("timed out after %v", s.timeout())
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.buf.String()); msg != "" {
+			return out, fmt.Errorf("%w: %s", err, msg)
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+// AuditEntry is one line of the tool audit log.
+type AuditEntry struct {
+	Time        time.Time       `json:"time"`
+	Prompt      string          `json:"prompt"`
+	Tool        string          `json:"tool"`
+	Command     string          `json:"command,omitempty"`
+	Args        json.RawMessage `json:"args"`
+	Declined    bool            `json:"declined,omitempty"`
+	ExitCode    int             `json:"exit_code"` // -1 if the command did not run or was killed
+	DurationMS  int64           `json:"duration_ms"`
+	OutputBytes int             `json:"output_bytes"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// auditPath returns the configured audit log, or
+// $XDG_STATE_HOME/pipellm/tools.jsonl.
+func (s *Sandbox) auditPath() (string, error) {
+	if s.AuditLog != "" {
+		return s.AuditLog, nil
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tools.jsonl"), nil
+}
+
+// exitCode returns the exit code of a finished command, or -1.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,205 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxHelperArg makes pipellm set up the sandbox inside the new
+// namespaces and then run the tool command; see runSandboxHelper.
+const sandboxHelperArg = "__pipellm-sandbox"
+
+type sandboxSpec struct {
+	Dir      string `json:"dir"`
+	ReadOnly bool   `json:"read_only"`
+	Seccomp  bool   `json:"seccomp"`
+	Script   string `json:"script"`
+}
+
+// command returns the command that runs script in dir. Without ReadOnly
+// or Isolate it is a plain shell in its own process group; otherwise
+// pipellm re-executes itself in new user and mount namespaces (and with
+// Isolate also PID, network, IPC and UTS namespaces) to finish the setup.
+func (s *Sandbox) command(ctx context.Context, dir, script string) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if !s.ReadOnly && !s.Isolate {
+		cmd = exec.CommandContext(ctx, "sh", "-c", script)
+	} else {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		spec, err := json.Marshal(sandboxSpec{Dir: dir, ReadOnly: s.ReadOnly, Seccomp: s.Isolate, Script: script})
+		if err != nil {
+			return nil, err
+		}
+		cmd = exec.CommandContext(ctx, self, sandboxHelperArg, string(spec))
+
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS
+		if s.Isolate {
+			attr.Cloneflags |= syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		}
+		// Root inside the namespace may mount, but is the user outside.
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	cmd.SysProcAttr = attr
+	// Kill the whole process group, not just the shell.
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd, nil
+}
+
+// runSandboxHelper finishes setting up the sandbox described by spec and
+// replaces the process with the tool command. It never returns.
+func runSandboxHelper(spec string) {
+	if err := sandboxExec(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "pipellm sandbox: %v\n", err)
+	}
+	os.Exit(126)
+}
+
+func sandboxExec(data string) error {
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		return err
+	}
+	// The seccomp filter applies to this thread, which then execs.
+	runtime.LockOSThread()
+
+	if spec.ReadOnly {
+		if err := mountReadOnly(spec.Dir); err != nil {
+			return fmt.Errorf("making %s read-only: %w", spec.Dir, err)
+		}
+	}
+	// Enter the new mount rather than the one underneath it.
+	if err := os.Chdir(spec.Dir); err != nil {
+		return err
+	}
+	if spec.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return fmt.Errorf("installing seccomp filter: %w", err)
+		}
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return err
+	}
+	return unix.Exec(sh, []string{"sh", "-c", spec.Script}, os.Environ())
+}
+
+func mountReadOnly(dir string) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+	if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+	// Flags locked by the outer namespace must be kept when remounting.
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return err
+	}
+	locked := uintptr(st.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME)
+	return unix.Mount("", dir, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|locked, "")
+}
+
+// seccompBlocked are syscalls a tool has no business making: changing
+// mounts or namespaces, tracing other processes, loading kernel code.
+var seccompBlocked = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_KEXEC_LOAD, unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+}
+
+var seccompArch = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}
+
+const cloneNamespaces = unix.CLONE_NEWNS | unix.CLONE_NEWUSER | unix.CLONE_NEWPID |
+	unix.CLONE_NEWNET | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS | unix.CLONE_NEWCGROUP
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// seccompFilter returns a BPF program that fails the blocked syscalls
+// and clone calls creating namespaces with EPERM, and kills the process
+// on syscalls of another architecture.
+func seccompFilter() ([]unix.SockFilter, error) {
+	arch, ok := seccompArch[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("not supported on %s", runtime.GOARCH)
+	}
+	const (
+		offsetNr   = 0
+		offsetArch = 4
+		offsetArg0 = 16 // low half on little-endian
+		eperm      = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	)
+
+	filter := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+	}
+	if runtime.GOARCH == "amd64" {
+		// x32 syscall numbers would bypass the checks below.
+		filter = append(filter,
+			bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, 0x40000000, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS))
+	}
+	for _, nr := range seccompBlocked {
+		filter = append(filter,
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, eperm))
+	}
+	// clone3 passes its flags in memory the filter cannot read; libc
+	// falls back to clone on ENOSYS.
+	filter = append(filter,
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE3, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE, 0, 3),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArg0),
+		bpfJump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, cloneNamespaces, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, eperm),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+	)
+	return filter, nil
+}
+
+func installSeccomp() error {
+	filter, err := seccompFilter()
+	if err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0)
+}
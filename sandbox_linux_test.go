@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// requireUserNamespaces skips the test where unprivileged user
+// namespaces are disabled.
+func requireUserNamespaces(t *testing.T) {
+	t.Helper()
+	if _, err := runSandbox(t, &Sandbox{ReadOnly: true}, "true"); err != nil {
+		t.Skipf("User namespaces unavailable: %v", err)
+	}
+}
+
+func TestSandboxReadOnly(t *testing.T) {
+	requireUserNamespaces(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o600)
+
+	s := &Sandbox{Dir: dir, ReadOnly: true}
+	out, err := runSandbox(t, s, "cat file")
+	if err != nil || out != "data" {
+		t.Errorf("Expected to read the file, got %q, %v", out, err)
+	}
+	if _, err := runSandbox(t, s, "echo changed > file"); err == nil || !strings.Contains(err.Error(), "Read-only file system") {
+		t.Errorf("Expected a read-only file system error, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "file")); string(data) != "data" {
+		t.Errorf("Expected the file to be unchanged, got %q", data)
+	}
+
+	// Outside the sandbox the directory stays writable.
+	if err := os.WriteFile(filepath.Join(dir, "other"), nil, 0o600); err != nil {
+		t.Errorf("Expected the directory to stay writable, got %v", err)
+	}
+}
+
+func TestSandboxIsolate(t *testing.T) {
+	requireUserNamespaces(t)
+	s := &Sandbox{Isolate: true}
+
+	ns, _ := os.Readlink("/proc/self/ns/net")
+	out, err := runSandbox(t, s, "readlink /proc/self/ns/net")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if strings.TrimSpace(out) == ns {
+		t.Errorf("Expected a separate network namespace, got %s", out)
+	}
+
+	// Ordinary commands still fork and run.
+	if out, err := runSandbox(t, s, "echo a | tr a b"); err != nil || out != "b\n" {
+		t.Errorf("Expected a pipeline to work, got %q, %v", out, err)
+	}
+	if _, err := exec.LookPath("unshare"); err == nil {
+		if _, err := runSandbox(t, s, "unshare -U true"); err == nil {
+			t.Error("Expected the seccomp filter to block new namespaces")
+		}
+	}
+}
+
+func TestSandboxMCPServer(t *testing.T) {
+	requireUserNamespaces(t)
+	ctx := context.Background()
+	c, err := startMCP(ctx, "test", testMCPServer("serve"), &Sandbox{Isolate: true})
+	if err != nil {
+		t.Fatalf("startMCP failed: %v", err)
+	}
+	defer c.Close()
+
+	ns, _ := os.Readlink("/proc/self/ns/net")
+	if server, _ := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", c.cmd.Process.Pid)); server == "" || server == ns {
+		t.Errorf("Expected the server in a separate network namespace, got %q", server)
+	}
+	if out, err := c.CallTool(ctx, "env.get", []byte(`{"name":"PIPELLM_TEST_MCP_SERVER"}`)); err != nil || out != "serve" {
+		t.Errorf("Expected the server to work in the sandbox, got %q, %v", out, err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const sandboxHelperArg = "__pipellm-sandbox"
+
+func (s *Sandbox) command(ctx context.Context, dir, script string) (*exec.Cmd, error) {
+	if s.ReadOnly || s.Isolate {
+		return nil, fmt.Errorf("sandbox read_only and isolate need Linux")
+	}
+	return exec.CommandContext(ctx, "sh", "-c", script), nil
+}
+
+func runSandboxHelper(spec string) {
+	fmt.Fprintln(os.Stderr, "pipellm sandbox: not supported on this platform")
+	os.Exit(126)
+}
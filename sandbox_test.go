@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runSandbox(t *testing.T, s *Sandbox, command string) (string, error) {
+	t.Helper()
+	return s.Run(context.Background(), Tool{ToolSpec: ToolSpec{Name: "test"}, Command: command}, []byte(`{"a":1}`))
+}
+
+func TestSandboxEnv(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "secret")
+	t.Setenv("LANG", "C.UTF-8")
+
+	out, err := runSandbox(t, &Sandbox{}, "env")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if strings.Contains(out, "GEMINI_API_KEY") || !strings.Contains(out, "LANG=C.UTF-8\n") || !strings.Contains(out, "PATH=") {
+		t.Errorf("Expected a scrubbed environment, got:\n%s", out)
+	}
+
+	out, err = runSandbox(t, &Sandbox{Env: []string{"GEMINI_API_KEY", "MODE=test"}}, "env")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(out, "GEMINI_API_KEY=secret\n") || !strings.Contains(out, "MODE=test\n") || strings.Contains(out, "LANG=") {
+		t.Errorf("Expected only the listed variables, got:\n%s", out)
+	}
+}
+
+func TestSandboxLimits(t *testing.T) {
+	dir := t.TempDir()
+	out, err := runSandbox(t, &Sandbox{Dir: dir}, "pwd; cat")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if resolved, _ := filepath.EvalSymlinks(dir); out != resolved+"\n{\"a\":1}" && out != dir+"\n{\"a\":1}" {
+		t.Errorf("Expected working directory and stdin, got %q", out)
+	}
+
+	out, err = runSandbox(t, &Sandbox{MaxOutputKB: 1}, "head -c 5000 /dev/zero | tr '\\0' x")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.HasPrefix(out, strings.Repeat("x", 1024)+"\n[output truncated at 1 KB]") {
+		t.Errorf("Expected output cut off at 1 KB, got %d bytes", len(out))
+	}
+
+	// Background children must not keep the call alive past the timeout.
+	start := time.Now()
+	_, err = runSandbox(t, &Sandbox{Timeout: "200ms"}, "sleep 10 & sleep 10")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be killed, took %v", elapsed)
+	}
+}
+
+func TestSandboxValidate(t *testing.T) {
+	for _, s := range []Sandbox{{Timeout: "soon"}, {Timeout: "-1s"}, {MaxOutputKB: -1}, {Env: []string{"=x"}}} {
+		if err := s.validate(); err == nil {
+			t.Errorf("Expected error for %+v, got nil", s)
+		}
+	}
+	if err := (&Sandbox{Timeout: "5s", MaxOutputKB: 16, Env: []string{"PATH", "A=b"}}).validate(); err != nil {
+		t.Errorf("Expected valid sandbox, got %v", err)
+	}
+}
+
+func TestToolAuditLog(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "audit", "tools.jsonl")
+	cfg := &Config{AllowedTools: []string{"*"}, Sandbox: Sandbox{AuditLog: log}}
+	prompt := &Prompt{Name: "agent", Tools: []Tool{
+		{ToolSpec: ToolSpec{Name: "ok"}, Command: "echo hello"},
+		{ToolSpec: ToolSpec{Name: "fail"}, Command: "exit 2"},
+		{ToolSpec: ToolSpec{Name: "guarded"}, Command: "echo no", Confirm: true},
+	}}
+	r, err := newToolRunner(context.Background(), cfg, prompt, false)
+	if err != nil {
+		t.Fatalf("newToolRunner failed: %v", err)
+	}
+	r.ask = func(string) (bool, error) { return false, nil }
+
+	ctx := context.Background()
+	r.run(ctx, ToolCall{Name: "ok", Args: map[string]any{"x": "y"}})
+	r.run(ctx, ToolCall{Name: "fail"})
+	r.run(ctx, ToolCall{Name: "guarded"})
+	r.run(ctx, ToolCall{Name: "missing"})
+
+	f, err := os.Open(log)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 audit entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Prompt != "agent" || e.Tool != "ok" || e.Command != "echo hello" || string(e.Args) != `{"x":"y"}` || e.ExitCode != 0 || e.OutputBytes != 6 {
+		t.Errorf("Unexpected entry for ok: %+v", e)
+	}
+	if e := entries[1]; e.ExitCode != 2 || e.Error == "" {
+		t.Errorf("Unexpected entry for fail: %+v", e)
+	}
+	if e := entries[2]; !e.Declined || e.ExitCode != -1 {
+		t.Errorf("Unexpected entry for guarded: %+v", e)
+	}
+	if e := entries[3]; e.Tool != "missing" || !strings.Contains(e.Error, "unknown tool") {
+		t.Errorf("Unexpected entry for missing: %+v", e)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	maxRequestBody = 10 << 20
+	sseKeepAlive   = 15 * time.Second
+)
+
+func cmdServe(args []string, profile string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.StringVar(&profile, "profile", profile, "Config profile to use")
+	listen := fs.String("listen", "localhost:8080", "Listen on `address`")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("usage: pipellm serve [--listen address]")
+	}
+
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+	token := os.Getenv("PIPELLM_TOKEN")
+	if token == "" && !isLoopback(*listen) {
+		return fmt.Errorf("refusing to listen on %s without a token; set PIPELLM_TOKEN", *listen)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Serving %d prompts on http://%s\n", len(cfg.Prompts), ln.Addr())
+	return serveHTTP(context.Background(), ln, newAPIServer(cfg, token))
+}
+
+// serveHTTP serves handler on ln until ctx is done or SIGINT or SIGTERM
+// arrives, then lets running requests finish.
+func serveHTTP(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return srv.Shutdown(shutdown)
+}
+
+// isLoopback reports whether a listen address only accepts local
+// connections.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopbackHost(host)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkLocal rejects requests a web page could have made, for servers
+// without a token. Browsers send Origin with cross-site POSTs, and a
+// page that rebinds its own name to 127.0.0.1 has that name as Host.
+func checkLocal(r *http.Request) error {
+	if r.Header.Get("Origin") != "" {
+		return errors.New("requests from web pages need a token; set PIPELLM_TOKEN")
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("unexpected host %q; use localhost or set PIPELLM_TOKEN", r.Host)
+	}
+	return nil
+}
+
+// apiServer is the HTTP API of pipellm serve.
+type apiServer struct {
+	cfg   *Config
+	token string // required as a bearer token if set
+	mux   *http.ServeMux
+}
+
+func newAPIServer(cfg *Config, token string) *apiServer {
+	s := &apiServer{cfg: cfg, token: token, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /v1/prompts", s.listPrompts)
+	s.mux.HandleFunc("POST /v1/prompts/{name}", s.runPrompt)
+	s.mux.HandleFunc("GET /v1/models", s.listModels)
+	s.mux.HandleFunc("POST /v1/chat/completions", s.chatCompletions)
+	return s
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token == "" {
+		if err := checkLocal(r); err != nil {
+			writeHTTPError(w, http.StatusForbidden, err)
+			return
+		}
+	} else {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pipellm"`)
+			writeHTTPError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+	}
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.mux.ServeHTTP(rec, r)
+	fmt.Fprintf(os.Stderr, "%s %s %d %v\n", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+}
+
+// statusRecorder remembers the status code for the request log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+type apiVariable struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Default     *string `json:"default,omitempty"`
+	Required    bool    `json:"required"`
+}
+
+type apiPrompt struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Model       string        `json:"model"`
+	Variables   []apiVariable `json:"variables,omitempty"`
+}
+
+func (s *apiServer) listPrompts(w http.ResponseWriter, r *http.Request) {
+	prompts := []apiPrompt{}
+	for _, p := range s.cfg.Prompts {
+		resolved := s.cfg.ResolvePrompt(p.Name)
+		ap := apiPrompt{Name: p.Name, Description: p.Description, Model: resolved.Model}
+		for _, v := range p.Variables {
+			ap.Variables = append(ap.Variables, apiVariable{v.Name, v.Description, v.Default, v.Default == nil})
+		}
+		prompts = append(prompts, ap)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"prompts": prompts})
+}
+
+// promptRequest is the JSON body of POST /v1/prompts/{name}. A body of
+// any other type is the input.
+type promptRequest struct {
+	Input string            `json:"input"`
+	Vars  map[string]string `json:"vars"`
+	Model string            `json:"model"` // overrides the prompt's models
+}
+
+func (s *apiServer) runPrompt(w http.ResponseWriter, r *http.Request) {
+	prompt := s.cfg.ResolvePrompt(r.PathValue("name"))
+	if prompt == nil {
+		writeHTTPError(w, http.StatusNotFound, fmt.Errorf("no prompt found for name: %s", r.PathValue("name")))
+		return
+	}
+	var body promptRequest
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+			return
+		}
+	} else {
+		body.Input = string(data)
+	}
+	if body.Model != "" {
+		prompt.Model, prompt.Fallbacks = body.Model, nil
+	}
+	if prompt, err = prompt.Expand(body.Vars); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	req := NewRequest(prompt, body.Input)
+	if !wantsEvents(r) {
+		env, err := s.answer(r.Context(), prompt, req, nil, nil)
+		if err != nil {
+			writeHTTPError(w, errorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, env)
+		return
+	}
+
+	// Text and tool calls are sent as they come; the answer event at the
+	// end carries the whole envelope.
+	stream := newEventStream(w)
+	stop := stream.keepAlive(sseKeepAlive)
+	env, err := s.answer(r.Context(), prompt, req,
+		func(call ToolCall) { stream.send("tool", call) },
+		func(text string) { stream.send("text", map[string]string{"text": text}) })
+	stop()
+	if err != nil {
+		stream.send("error", map[string]string{"error": err.Error()})
+		return
+	}
+	stream.send("answer", env)
+}
+
+// answer runs a prompt like the command line does. Tool calls are
+// reported to onTool and the text is streamed to onText, either of
+// which may be nil.
+func (s *apiServer) answer(ctx context.Context, prompt *Prompt, req *Request, onTool func(ToolCall), onText func(string)) (*Envelope, error) {
+	tools, err := newServerToolRunner(ctx, s.cfg, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if tools != nil {
+		defer tools.Close()
+		req.Tools = tools.specs
+		tools.observe = onTool
+	}
+
+	start := time.Now()
+	_, res, err := answer(ctx, s.cfg, prompt, req, tools, &runOptions{onText: onText})
+	if err != nil {
+		return nil, err
+	}
+	env := NewEnvelope(res.provider, res.model, res.resp, time.Since(start))
+	env.Cached = res.cached
+	env.Fallbacks = res.failed
+	return env, nil
+}
+
+// newServerToolRunner is newToolRunner for servers, which have no
+// terminal to ask for confirmation on: calls that need it are declined.
+func newServerToolRunner(ctx context.Context, cfg *Config, p *Prompt) (*toolRunner, error) {
+	tools, err := newToolRunner(ctx, cfg, p, false)
+	if tools != nil {
+		tools.ask = func(string) (bool, error) {
+			return false, errors.New("no terminal to confirm the call on")
+		}
+	}
+	return tools, err
+}
+
+// wantsEvents reports whether the client asked for the answer as
+// server-sent events, with Accept or ?events=true.
+func wantsEvents(r *http.Request) bool {
+	if events := r.URL.Query().Get("events"); events != "" {
+		return events == "true" || events == "1"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// errorStatus maps the error of a prompt run to an HTTP status.
+func errorStatus(err error) int {
+	var budgetErr *BudgetError
+	switch {
+	case errors.As(err, &budgetErr):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrBlocked):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// eventStream writes server-sent events. It is safe for concurrent use.
+type eventStream struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return &eventStream{w: w, rc: http.NewResponseController(w)}
+}
+
+func (s *eventStream) write(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.w, text)
+	s.rc.Flush()
+}
+
+// send writes one event with v as JSON data.
+func (s *eventStream) send(event string, v any) {
+	data, _ := json.Marshal(v)
+	s.write(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+}
+
+// sendData writes an unnamed event with v as JSON data.
+func (s *eventStream) sendData(v any) {
+	data, _ := json.Marshal(v)
+	s.write(fmt.Sprintf("data: %s\n\n", data))
+}
+
+// keepAlive sends a comment every interval so that proxies keep the
+// connection open, until the returned function is called.
+func (s *eventStream) keepAlive(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.write(": keep-alive\n\n")
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOllama answers chat requests with reply, or with a call of the
+// first tool if the request offers tools that were not called yet.
+// Streamed, the reply comes a word at a time.
+type fakeOllama struct {
+	mu       sync.Mutex
+	requests []ollamaChatRequest
+	reply    string
+	fail     bool
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ollamaChatRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+	if f.fail {
+		http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+		return
+	}
+
+	msg := ollamaMessage{Role: "assistant", Content: f.reply}
+	if len(req.Tools) > 0 && req.Messages[len(req.Messages)-1].Role != "tool" {
+		var call ollamaToolCall
+		call.Function.Name = req.Tools[0].Function.Name
+		call.Function.Arguments = map[string]any{}
+		msg = ollamaMessage{Role: "assistant", ToolCalls: []ollamaToolCall{call}}
+	}
+	last := ollamaChatResponse{Model: req.Model, Message: msg, Done: true, DoneReason: "stop", PromptEvalCount: 10, EvalCount: 5}
+	enc := json.NewEncoder(w)
+	if !req.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		enc.Encode(last)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, word := range strings.SplitAfter(msg.Content, " ") {
+		enc.Encode(ollamaChatResponse{Model: req.Model, Message: ollamaMessage{Role: "assistant", Content: word}})
+	}
+	last.Message.Content = ""
+	enc.Encode(last)
+}
+
+func newTestAPI(t *testing.T, token string) (*httptest.Server, *fakeOllama) {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	backend := &fakeOllama{reply: "Looks good."}
+	ollama := httptest.NewServer(backend)
+	t.Cleanup(ollama.Close)
+
+	cfg := &Config{Provider: "ollama", Endpoint: ollama.URL, Model: "llama3", AllowedTools: []string{"answer"}, Prompts: []Prompt{
+		{Name: "review", Description: "Review code", Prompt: "Review this {{language}} code:", Variables: []Variable{{Name: "language", Description: "Language"}}},
+		{Name: "summarize", Prompt: "Summarize:"},
+		{Name: "agent", Prompt: "Find out:", Tools: []Tool{{ToolSpec: ToolSpec{Name: "answer"}, Command: "echo 42"}}},
+	}}
+	server := httptest.NewServer(newAPIServer(cfg, token))
+	t.Cleanup(server.Close)
+	return server, backend
+}
+
+func doRequest(t *testing.T, method, url, token, contentType, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestServeAuth(t *testing.T) {
+	server, _ := newTestAPI(t, "secret")
+	for _, token := range []string{"", "wrong"} {
+		resp, body := doRequest(t, "GET", server.URL+"/v1/prompts", token, "", "")
+		if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(body, "invalid token") {
+			t.Errorf("Token %q: expected 401, got %d %s", token, resp.StatusCode, body)
+		}
+	}
+	if resp, body := doRequest(t, "GET", server.URL+"/v1/prompts", "secret", "", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 with the token, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServeLocalOnly(t *testing.T) {
+	server, backend := newTestAPI(t, "")
+	tests := []struct {
+		host, origin string
+		status       int
+	}{
+		{"", "", http.StatusOK},
+		{"localhost:8080", "", http.StatusOK},
+		{"[::1]:8080", "", http.StatusOK},
+		{"", "https://evil.example", http.StatusForbidden},
+		{"evil.example:8080", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", server.URL+"/v1/prompts/summarize", strings.NewReader("x"))
+		req.Header.Set("Content-Type", "text/plain")
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Host %q, Origin %q: expected %d, got %d", tt.host, tt.origin, tt.status, resp.StatusCode)
+		}
+	}
+	if len(backend.requests) != 3 {
+		t.Errorf("Expected only the local requests to run, got %d", len(backend.requests))
+	}
+
+	// With a token, the token is what counts.
+	server, _ = newTestAPI(t, "secret")
+	req, _ := http.NewRequest("GET", server.URL+"/v1/prompts", nil)
+	req.Host = "pipellm.example"
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Authorization", "Bearer secret")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the token to be enough, got %v, %v", resp, err)
+	}
+}
+
+func TestServeListPrompts(t *testing.T) {
+	server, _ := newTestAPI(t, "")
+	resp, body := doRequest(t, "GET", server.URL+"/v1/prompts", "", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", resp.StatusCode, body)
+	}
+	var list struct{ Prompts []apiPrompt }
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("Invalid response %s: %v", body, err)
+	}
+	if len(list.Prompts) != 3 {
+		t.Fatalf("Expected 3 prompts, got %+v", list.Prompts)
+	}
+	p := list.Prompts[0]
+	if p.Name != "review" || p.Description != "Review code" || p.Model != "llama3" || len(p.Variables) != 1 || !p.Variables[0].Required {
+		t.Errorf("Unexpected prompt %+v", p)
+	}
+}
+
+func TestServeRunPrompt(t *testing.T) {
+	server, backend := newTestAPI(t, "")
+
+	resp, body := doRequest(t, "POST", server.URL+"/v1/prompts/summarize", "", "text/plain", "Long text")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", resp.StatusCode, body)
+	}
+	var env Envelope
+	json.Unmarshal([]byte(body), &env)
+	if env.Text != "Looks good." || env.Provider != "ollama" || env.Model != "llama3" || env.Usage.OutputTokens != 5 {
+		t.Errorf("Unexpected envelope %+v", env)
+	}
+	if got := backend.requests[0].Messages[0].Content; got != "Summarize:\n\nLong text" {
+		t.Errorf("Expected the body as input, got %q", got)
+	}
+
+	resp, body = doRequest(t, "POST", server.URL+"/v1/prompts/review", "", "application/json",
+		`{"input": "x := 1", "vars": {"language": "Go"}, "model": "codellama"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", resp.StatusCode, body)
+	}
+	if last := backend.requests[1]; last.Model != "codellama" || last.Messages[0].Content != "Review this Go code:\n\nx := 1" {
+		t.Errorf("Unexpected request to the model: %+v", last)
+	}
+
+	tests := []struct {
+		path, contentType, body string
+		status                  int
+	}{
+		{"/v1/prompts/missing", "text/plain", "x", http.StatusNotFound},
+		{"/v1/prompts/review", "text/plain", "x", http.StatusBadRequest},
+		{"/v1/prompts/review", "application/json", "{", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, body := doRequest(t, "POST", server.URL+tt.path, "", tt.contentType, tt.body)
+		if resp.StatusCode != tt.status || !strings.Contains(body, `"error"`) {
+			t.Errorf("%s %q: expected %d with an error, got %d %s", tt.path, tt.body, tt.status, resp.StatusCode, body)
+		}
+	}
+
+	backend.fail = true
+	if resp, body := doRequest(t, "POST", server.URL+"/v1/prompts/summarize", "", "text/plain", "x"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected 502 for a failing backend, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServeEventStream(t *testing.T) {
+	server, backend := newTestAPI(t, "")
+
+	resp, body := doRequest(t, "POST", server.URL+"/v1/prompts/agent?events=true", "", "text/plain", "the answer")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s: %s", ct, body)
+	}
+	events := strings.Split(strings.TrimSpace(body), "\n\n")
+	want := []string{
+		`event: tool` + "\n" + `data: {"name":"answer","args":{}}`,
+		`event: text` + "\n" + `data: {"text":"Looks "}`,
+		`event: text` + "\n" + `data: {"text":"good."}`,
+	}
+	if len(events) != 4 || strings.Join(events[:3], "\n\n") != strings.Join(want, "\n\n") || !strings.HasPrefix(events[3], "event: answer\ndata: ") {
+		t.Fatalf("Unexpected events:\n%s", body)
+	}
+	var env Envelope
+	json.Unmarshal([]byte(strings.TrimPrefix(events[3], "event: answer\ndata: ")), &env)
+	if env.Text != "Looks good." || env.Usage.OutputTokens != 10 {
+		t.Errorf("Expected the answer after the tool call, got %+v", env)
+	}
+	if last := backend.requests[len(backend.requests)-1].Messages; last[len(last)-1].Content != `{"output":"42\n"}` {
+		t.Errorf("Expected the tool output to reach the model, got %+v", last)
+	}
+
+	backend.fail = true
+	req, _ := http.NewRequest("POST", server.URL+"/v1/prompts/summarize", strings.NewReader("x"))
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	data, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.HasPrefix(string(data), "event: error\ndata: {\"error\":") {
+		t.Errorf("Expected an error event, got %s", data)
+	}
+}
+
+func TestServeCancelsProviderCall(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	cancelled := make(chan struct{})
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server notices a closed connection once the body is read.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer ollama.Close()
+	cfg := &Config{Provider: "ollama", Endpoint: ollama.URL, Model: "llama3", Prompts: []Prompt{{Name: "summarize", Prompt: "Summarize:"}}}
+	server := httptest.NewServer(newAPIServer(cfg, ""))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/v1/prompts/summarize", strings.NewReader("x"))
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatal("Expected the request to time out")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the provider call to be cancelled with the request")
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"localhost:8080": true,
+		"127.0.0.1:80":   true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.5:8080":  false,
+		"8080":           false,
+	} {
+		if got := isLoopback(addr); got != expected {
+			t.Errorf("isLoopback(%q): expected %v, got %v", addr, expected, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Transcript is a saved conversation, used for --session and the chat
+// /save and /load commands.
+type Transcript struct {
+	Prompt  string    `json:"prompt"`
+	Model   string    `json:"model"`
+	History []Message `json:"history"`
+}
+
+var sessionNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// stateDir returns $XDG_STATE_HOME/pipellm, or ~/.local/state/pipellm.
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "pipellm"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "pipellm"), nil
+}
+
+func sessionPath(name string) (string, error) {
+	if !sessionNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid session name %q (use letters, digits, '.', '-' and '_')", name)
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions", name+".json"), nil
+}
+
+// loadSession reads a session file. A missing file is a new, empty session.
+func loadSession(path string) (*Transcript, error) {
+	t, err := LoadTranscript(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Transcript{}, nil
+	}
+	return t, err
+}
+
+func LoadTranscript(path string) (*Transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Save writes the transcript to path, creating its directory. Transcripts
+// hold whatever was piped in, so they are only readable by the owner.
+func (t *Transcript) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// Record sets the transcript to the conversation that req, the last
+// request of a completed turn, and resp ended.
+func (t *Transcript) Record(prompt *Prompt, req *Request, resp *Response) {
+	t.Prompt, t.Model = prompt.Name, prompt.Model
+	t.History = append(append([]Message(nil), req.History...), req.turn(), resp.turn())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSessionPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	path, err := sessionPath("pr42")
+	if err != nil {
+		t.Fatalf("sessionPath failed: %v", err)
+	}
+	expected := filepath.Join(dir, "pipellm", "sessions", "pr42.json")
+	if path != expected {
+		t.Errorf("Expected %s, got %s", expected, path)
+	}
+
+	for _, name := range []string{"", "../etc", "a/b", ".hidden"} {
+		if _, err := sessionPath(name); err == nil {
+			t.Errorf("Expected error for session name %q, got nil", name)
+		}
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions", "pr42.json")
+
+	session, err := loadSession(path)
+	if err != nil {
+		t.Fatalf("loadSession failed for a new session: %v", err)
+	}
+	if len(session.History) != 0 {
+		t.Errorf("Expected empty history, got %+v", session.History)
+	}
+
+	prompt := &Prompt{Name: "review", Prompt: "Review this diff.", Model: "gemini-2.5-pro"}
+	req := NewRequest(prompt, "diff --git a/x b/x")
+	session.Record(prompt, req, &Response{Text: "Looks good."})
+	if err := session.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat session: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	loaded, err := loadSession(path)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	expected := &Transcript{
+		Prompt: "review",
+		Model:  "gemini-2.5-pro",
+		History: []Message{
+			{Role: "user", Parts: []string{"Review this diff.\n\ndiff --git a/x b/x"}},
+			{Role: "model", Parts: []string{"Looks good."}},
+		},
+	}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, loaded)
+	}
+}
+
+func TestLoadSessionInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.json")
+	os.WriteFile(path, []byte("{not json"), 0o600)
+
+	if _, err := loadSession(path); err == nil {
+		t.Error("Expected error for invalid session file, got nil")
+	}
+}
+
+func TestNewRequestWithoutPromptText(t *testing.T) {
+	// Follow-up turns of a session send only the new input.
+	req := NewRequest(&Prompt{System: "Be brief."}, "what about the locking?")
+	if len(req.Parts) != 1 || req.Parts[0] != "what about the locking?" {
+		t.Errorf("Unexpected parts: %q", req.Parts)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var validAliasName = regexp.MustCompile(`^[a-z0-9_][a-z0-9_.-]*$`)
+
+var shellKeywords = map[string]bool{
+	"alias": true, "bg": true, "bind": true, "break": true, "builtin": true,
+	"case": true, "cd": true, "command": true, "compgen": true, "complete": true,
+	"continue": true, "declare": true, "dirs": true, "disown": true, "do": true,
+	"done": true, "echo": true, "elif": true, "else": true, "enable": true,
+	"end": true, "esac": true, "eval": true, "exec": true, "exit": true,
+	"export": true, "false": true, "fc": true, "fg": true, "fi": true,
+	"for": true, "function": true, "getopts": true, "hash": true, "help": true,
+	"history": true, "if": true, "in": true, "jobs": true, "kill": true,
+	"let": true, "local": true, "logout": true, "popd": true, "printf": true,
+	"pushd": true, "pwd": true, "read": true, "readonly": true, "return": true,
+	"select": true, "set": true, "shift": true, "source": true, "test": true,
+	"then": true, "time": true, "trap": true, "true": true, "type": true,
+	"typeset": true, "ulimit": true, "umask": true, "unalias": true, "unset": true,
+	"until": true, "wait": true, "while": true,
+}
+
+// shellCommands lists the prompt names that can be installed as
+// commands, warning about the ones that are skipped.
+func shellCommands(cfg *Config, execPath string, warn io.Writer) []string {
+	var names []string
+	for _, p := range cfg.Prompts {
+		name := strings.ToLower(strings.TrimSpace(p.Name))
+		switch {
+		case !validAliasName.MatchString(name):
+			fmt.Fprintf(warn, "Skipping prompt %q: not a valid command name\n", p.Name)
+		case shadowsCommand(name, execPath):
+			fmt.Fprintf(warn, "Skipping prompt %q: shadows an existing command\n", p.Name)
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func shadowsCommand(name, execPath string) bool {
+	if name == "pipellm" || shellKeywords[name] || isSubcommand(name) {
+		return true
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return false
+	}
+	// Links to pipellm itself (see install-links), including those of an
+	// older or moved binary, are not shadowed commands.
+	if target, err := os.Readlink(path); err == nil && isPipellmLink(path, target, execPath) {
+		return false
+	}
+	return !sameFile(path, execPath)
+}
+
+func sameFile(a, b string) bool {
+	ra, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+	rb, err := filepath.EvalSymlinks(b)
+	if err != nil {
+		return false
+	}
+	return ra == rb
+}
+
+func runFlagNames() []string {
+	var opts runOptions
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	opts.register(fs, "")
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "--"+f.Name)
+	})
+	return names
+}
+
+func profileNames(cfg *Config) []string {
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func generateShell(shell, profile string) error {
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		return err
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("getting executable path: %w", err)
+	}
+
+	names := shellCommands(cfg, execPath, os.Stderr)
+	return writeShellInit(os.Stdout, shell, execPath, names, cfg)
+}
+
+// writeShellInit writes aliases for the prompt commands in names and
+// tab completion for them and for pipellm itself.
+func writeShellInit(w io.Writer, shell, execPath string, names []string, cfg *Config) error {
+	var prompts []string
+	for _, p := range cfg.Prompts {
+		if name := strings.ToLower(strings.TrimSpace(p.Name)); validAliasName.MatchString(name) {
+			prompts = append(prompts, name)
+		}
+	}
+	c := completion{
+		commands: append(append([]string{}, subcommands...), prompts...),
+		prompts:  prompts,
+		flags:    runFlagNames(),
+		profiles: profileNames(cfg),
+	}
+
+	switch shell {
+	case "bash", "zsh":
+		for _, name := range names {
+			fmt.Fprintf(w, "alias %s=%s\n", name, shQuote(shQuote(execPath)+" "+name))
+		}
+		if shell == "zsh" {
+			fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		}
+		c.bash(w, names)
+	case "fish":
+		for _, name := range names {
+			fmt.Fprintf(w, "function %s --description %s\n    %s %s $argv\nend\n",
+				name, fishQuote("pipellm "+name), fishQuote(execPath), name)
+		}
+		c.fish(w, names)
+	case "pwsh":
+		for _, name := range names {
+			fmt.Fprintf(w, "function %s { & %s %s @args }\n", name, pwshQuote(execPath), name)
+		}
+		c.pwsh(w, names)
+	default:
+		return fmt.Errorf("unsupported shell %q (want bash, zsh, fish or pwsh)", shell)
+	}
+	return nil
+}
+
+type completion struct {
+	commands []string
+	prompts  []string
+	flags    []string
+	profiles []string
+}
+
+func (c completion) bash(w io.Writer, names []string) {
+	fmt.Fprintf(w, `_pipellm_complete() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    local words=%s
+    if [[ $prev == --profile ]]; then
+        words=%s
+    elif [[ $cur == -* ]]; then
+        words=%s
+    elif [[ ${COMP_WORDS[0]##*/} == pipellm && $COMP_CWORD -eq 1 ]]; then
+        words=%s
+    elif [[ ${COMP_WORDS[0]##*/} == pipellm && $COMP_CWORD -eq 2 && ($prev == show || $prev == run) ]]; then
+        words=%s
+    fi
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -F _pipellm_complete pipellm %s
+`, shQuote(""), shQuote(strings.Join(c.profiles, " ")), shQuote(strings.Join(c.flags, " ")),
+		shQuote(strings.Join(c.commands, " ")), shQuote(strings.Join(c.prompts, " ")),
+		strings.Join(names, " "))
+}
+
+func (c completion) fish(w io.Writer, names []string) {
+	fmt.Fprintf(w, "complete -c pipellm -f -n __fish_use_subcommand -a %s\n", fishQuote(strings.Join(c.commands, " ")))
+	fmt.Fprintf(w, "complete -c pipellm -f -n '__fish_seen_subcommand_from show run' -a %s\n", fishQuote(strings.Join(c.prompts, " ")))
+	for _, cmd := range append([]string{"pipellm"}, names...) {
+		for _, f := range c.flags {
+			name := strings.TrimPrefix(f, "--")
+			if name == "profile" {
+				fmt.Fprintf(w, "complete -c %s -l %s -x -a %s\n", cmd, name, fishQuote(strings.Join(c.profiles, " ")))
+			} else {
+				fmt.Fprintf(w, "complete -c %s -l %s\n", cmd, name)
+			}
+		}
+	}
+}
+
+func (c completion) pwsh(w io.Writer, names []string) {
+	list := func(words []string) string {
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			quoted[i] = pwshQuote(word)
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+
+	fmt.Fprintf(w, `Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $elements = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })
+    if ($wordToComplete) { $elements = @($elements | Select-Object -SkipLast 1) }
+    $command = [System.IO.Path]::GetFileNameWithoutExtension($elements[0])
+    $words = @()
+    if ($elements[-1] -eq '--profile') {
+        $words = %s
+    } elseif ($wordToComplete -like '-*') {
+        $words = %s
+    } elseif ($command -eq 'pipellm' -and $elements.Count -eq 1) {
+        $words = %s
+    } elseif ($command -eq 'pipellm' -and $elements.Count -eq 2 -and $elements[1] -in 'show', 'run') {
+        $words = %s
+    }
+    $words | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`, list(append([]string{"pipellm"}, names...)), list(c.profiles), list(c.flags), list(c.commands), list(c.prompts))
+}
+
+// shQuote quotes s for POSIX shells.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func pwshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellQuoting(t *testing.T) {
+	path := `/opt/it's\here/pipellm`
+
+	if got, want := shQuote(path), `'/opt/it'\''s\here/pipellm'`; got != want {
+		t.Errorf("shQuote() = %s, expected %s", got, want)
+	}
+	if got, want := fishQuote(path), `'/opt/it\'s\\here/pipellm'`; got != want {
+		t.Errorf("fishQuote() = %s, expected %s", got, want)
+	}
+	if got, want := pwshQuote(path), `'/opt/it''s\here/pipellm'`; got != want {
+		t.Errorf("pwshQuote() = %s, expected %s", got, want)
+	}
+}
+
+func TestWriteShellInit(t *testing.T) {
+	cfg := &Config{
+		Prompts:  []Prompt{{Name: "Summary", Prompt: "x"}, {Name: "bad name", Prompt: "y"}},
+		Profiles: map[string]Profile{"work": {}},
+	}
+	execPath := "/opt/it's/pipellm"
+
+	tests := []struct {
+		shell    string
+		expected []string
+	}{
+		{"bash", []string{
+			`alias summary=''\''/opt/it'\''\'\'''\''s/pipellm'\'' summary'`,
+			"complete -F _pipellm_complete pipellm summary\n",
+			" --model ",
+			"words='work'",
+		}},
+		{"zsh", []string{
+			"autoload -U +X bashcompinit && bashcompinit",
+			"complete -F _pipellm_complete pipellm summary\n",
+		}},
+		{"fish", []string{
+			"function summary --description 'pipellm summary'\n    '/opt/it\\'s/pipellm' summary $argv\nend",
+			"complete -c summary -l profile -x -a 'work'",
+			"complete -c pipellm -l model",
+		}},
+		{"pwsh", []string{
+			"function summary { & '/opt/it''s/pipellm' summary @args }",
+			"-CommandName @('pipellm', 'summary')",
+			"'--model', ",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeShellInit(&buf, tt.shell, execPath, []string{"summary"}, cfg); err != nil {
+				t.Fatalf("writeShellInit failed: %v", err)
+			}
+			out := buf.String()
+			for _, want := range tt.expected {
+				if !strings.Contains(out, want) {
+					t.Errorf("Expected output to contain %q, got:\n%s", want, out)
+				}
+			}
+			if strings.Contains(out, "bad name") {
+				t.Errorf("Invalid prompt name leaked into output:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestWriteShellInitUnsupported(t *testing.T) {
+	err := writeShellInit(io.Discard, "tcsh", "/bin/pipellm", nil, &Config{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported shell") {
+		t.Errorf("Expected unsupported shell error, got %v", err)
+	}
+}
+
+func TestShellCommands(t *testing.T) {
+	cfg := &Config{Prompts: []Prompt{
+		{Name: "Summary"},
+		{Name: "has space"},
+		{Name: "-dash"},
+		{Name: "cd"},
+		{Name: "list"},
+		{Name: "sh"},
+		{Name: "pipellm-unlikely-command-name"},
+	}}
+
+	var warnings bytes.Buffer
+	names := shellCommands(cfg, "/nonexistent/pipellm", &warnings)
+
+	if strings.Join(names, ",") != "summary,pipellm-unlikely-command-name" {
+		t.Errorf("Unexpected command names %v", names)
+	}
+	for _, skipped := range []string{`"has space"`, `"-dash"`, `"cd"`, `"list"`, `"sh"`} {
+		if !strings.Contains(warnings.String(), skipped) {
+			t.Errorf("Expected warning for %s, got:\n%s", skipped, warnings.String())
+		}
+	}
+}
+
+func TestShellCommandsOldLinks(t *testing.T) {
+	binDir, oldDir := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", binDir)
+	// A link of an older install, and a foreign command.
+	oldPath := filepath.Join(oldDir, "pipellm")
+	os.WriteFile(oldPath, []byte("binary"), 0o755)
+	os.Symlink(oldPath, filepath.Join(binDir, "review"))
+	os.WriteFile(filepath.Join(binDir, "other"), []byte("#!/bin/sh\n"), 0o755)
+
+	cfg := &Config{Prompts: []Prompt{{Name: "review"}, {Name: "other"}}}
+	var warnings bytes.Buffer
+	names := shellCommands(cfg, "/nonexistent/new/pipellm", &warnings)
+	if strings.Join(names, ",") != "review" {
+		t.Errorf("Expected the old link to be kept, got %v (%s)", names, warnings.String())
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func ttyWidth(f *os.File) int {
+	return 0
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultMaxToolRounds limits how often the model may call tools before
+// answering, unless the prompt sets max_tool_rounds.
+const defaultMaxToolRounds = 10
+
+// Tool is a local command the model may call. The call's arguments are
+// passed to the command as a JSON object on stdin and its stdout is
+// returned to the model.
+type Tool struct {
+	ToolSpec `yaml:",inline"`
+	Command  string `yaml:"command"`
+	Confirm  bool   `yaml:"confirm"` // ask before every call
+
+	// For tools of an MCP server, the server and its name for the tool.
+	server *mcpClient
+	remote string
+}
+
+// origin describes what runs when t is called.
+func (t *Tool) origin() string {
+	if t.server != nil {
+		return "mcp:" + t.server.name + "/" + t.remote
+	}
+	return t.Command
+}
+
+// ToolSpec is what the model is told about a tool.
+type ToolSpec struct {
+	Name        string  `yaml:"name"`
+	Description string  `yaml:"description"`
+	Parameters  *Schema `yaml:"parameters"`
+}
+
+// Schema is the subset of JSON schema that Gemini accepts for tool
+// parameters.
+type Schema struct {
+	Type        string             `yaml:"type" json:"type,omitempty"`
+	Format      string             `yaml:"format" json:"format,omitempty"`
+	Description string             `yaml:"description" json:"description,omitempty"`
+	Nullable    bool               `yaml:"nullable" json:"nullable,omitempty"`
+	Enum        []string           `yaml:"enum" json:"enum,omitempty"`
+	Items       *Schema            `yaml:"items" json:"items,omitempty"`
+	Properties  map[string]*Schema `yaml:"properties" json:"properties,omitempty"`
+	Required    []string           `yaml:"required" json:"required,omitempty"`
+}
+
+var schemaTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true,
+}
+
+func (s *Schema) validate(path string) error {
+	if s == nil {
+		return nil
+	}
+	if !schemaTypes[strings.ToLower(s.Type)] {
+		return fmt.Errorf("%s: unknown type %q", path, s.Type)
+	}
+	if err := s.Items.validate(path + ".items"); err != nil {
+		return err
+	}
+	for name, p := range s.Properties {
+		if err := p.validate(path + "." + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToolCall is a call of a tool requested by the model.
+type ToolCall struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// ToolResult is the answer to a ToolCall. Error is set if the tool
+// could not be run or failed.
+type ToolResult struct {
+	Name   string `json:"name"`
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// response returns r as the object the model receives.
+func (r ToolResult) response() map[string]any {
+	out := map[string]any{"output": r.Output}
+	if r.Error != "" {
+		out["error"] = r.Error
+	}
+	return out
+}
+
+var toolNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]{0,63}$`)
+
+func (t *Tool) validate() error {
+	if !toolNameRe.MatchString(t.Name) {
+		return fmt.Errorf("invalid tool name %q (use up to 64 letters, digits, '_' and '-')", t.Name)
+	}
+	if strings.TrimSpace(t.Command) == "" {
+		return fmt.Errorf("tool %q has no command", t.Name)
+	}
+	if t.Parameters != nil && !strings.EqualFold(t.Parameters.Type, "object") {
+		return fmt.Errorf("tool %q: parameters must be of type object", t.Name)
+	}
+	if err := t.Parameters.validate(t.Name + ".parameters"); err != nil {
+		return fmt.Errorf("tool %w", err)
+	}
+	return nil
+}
+
+// toolRunner runs the tool calls of one prompt.
+type toolRunner struct {
+	prompt    string
+	tools     map[string]Tool
+	specs     []ToolSpec
+	confirm   bool // ask before every call
+	maxRounds int
+
+	log     io.Writer      // reports calls, if not nil
+	observe func(ToolCall) // called before every call, if not nil
+	exec    func(ctx context.Context, t Tool, args []byte) (string, error)
+	ask     func(question string) (bool, error)
+	audit   func(e AuditEntry) error // records every call, if not nil
+	servers []*mcpClient
+}
+
+// newToolRunner returns a runner for the prompt's tools that the config
+// allows, or nil if there are none. It starts the prompt's MCP servers,
+// which run until Close.
+func newToolRunner(ctx context.Context, cfg *Config, p *Prompt, confirm bool) (*toolRunner, error) {
+	sandbox := cfg.Sandbox
+	r := &toolRunner{
+		prompt:    p.Name,
+		tools:     make(map[string]Tool),
+		confirm:   confirm || p.ConfirmTools,
+		maxRounds: p.MaxToolRounds,
+		exec: func(ctx context.Context, t Tool, args []byte) (string, error) {
+			if t.server == nil {
+				return sandbox.Run(ctx, t, args)
+			}
+			ctx, cancel := context.WithTimeout(ctx, sandbox.timeout())
+			defer cancel()
+			out, err := t.server.CallTool(ctx, t.remote, args)
+			if err != nil {
+				return "", errors.New(sandbox.truncate(err.Error()))
+			}
+			return sandbox.truncate(out), nil
+		},
+		ask: askTerminal,
+		audit: func(e AuditEntry) error {
+			path, err := sandbox.auditPath()
+			if err != nil {
+				return err
+			}
+			return appendJSONLine(path, e)
+		},
+	}
+	if r.maxRounds == 0 {
+		r.maxRounds = defaultMaxToolRounds
+	}
+	tools := p.Tools
+	for _, name := range p.MCP {
+		server, ok := cfg.MCPServers[name]
+		if !ok {
+			r.Close()
+			return nil, fmt.Errorf("unknown mcp server %q", name)
+		}
+		client, err := startMCP(ctx, name, server, &sandbox)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.servers = append(r.servers, client)
+		served, err := client.ListTools(ctx)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		for _, mt := range served {
+			params, err := mcpSchema(mt.InputSchema)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping tool %s of mcp server %s: %v\n", mt.Name, name, err)
+				continue
+			}
+			tools = append(tools, Tool{
+				ToolSpec: ToolSpec{Name: mcpToolName(name, mt.Name), Description: mt.Description, Parameters: params},
+				Confirm:  server.Confirm,
+				server:   client,
+				remote:   mt.Name,
+			})
+		}
+	}
+
+	for _, t := range tools {
+		if !cfg.toolAllowed(t.Name) {
+			if t.server == nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %s, not in allowed_tools\n", t.origin())
+			}
+			continue
+		}
+		if _, ok := r.tools[t.Name]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s, another tool has the same name\n", t.origin())
+			continue
+		}
+		r.tools[t.Name] = t
+		r.specs = append(r.specs, t.ToolSpec)
+	}
+	if len(r.specs) == 0 {
+		r.Close()
+		return nil, nil
+	}
+	return r, nil
+}
+
+// declaredTools returns the specs of the tools p declares itself that
+// the config allows. Unlike newToolRunner it starts no MCP servers.
+func declaredTools(cfg *Config, p *Prompt) []ToolSpec {
+	var specs []ToolSpec
+	seen := make(map[string]bool)
+	for _, t := range p.Tools {
+		if !cfg.toolAllowed(t.Name) || seen[t.Name] {
+			continue
+		}
+		seen[t.Name] = true
+		specs = append(specs, t.ToolSpec)
+	}
+	return specs
+}
+
+// Close stops the runner's MCP servers.
+func (r *toolRunner) Close() {
+	for _, s := range r.servers {
+		s.Close()
+	}
+	r.servers = nil
+}
+
+// toolAllowed reports whether name matches the allowed_tools list, whose
+// entries may be patterns such as "fs__*". Tools not on the list, or all
+// of them without one, are never offered to the model.
+func (c *Config) toolAllowed(name string) bool {
+	for _, allowed := range c.AllowedTools {
+		if ok, _ := path.Match(allowed, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// run executes call and records it in the audit log. Failures are
+// reported to the model in the result rather than ending the
+// conversation.
+func (r *toolRunner) run(ctx context.Context, call ToolCall) ToolResult {
+	result := ToolResult{Name: call.Name}
+	args, err := json.Marshal(call.Args)
+	if err != nil || call.Args == nil {
+		args = []byte("{}")
+	}
+	entry := AuditEntry{Time: time.Now().UTC(), Prompt: r.prompt, Tool: call.Name, Args: args, ExitCode: -1}
+	defer func() {
+		if r.audit == nil {
+			return
+		}
+		entry.OutputBytes = len(result.Output)
+		entry.Error = result.Error
+		if err := r.audit(entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: writing tool audit log: %v\n", err)
+		}
+	}()
+
+	t, ok := r.tools[call.Name]
+	if !ok {
+		result.Error = fmt.Sprintf("unknown tool %q", call.Name)
+		return result
+	}
+	entry.Command = t.origin()
+	if r.log != nil {
+		fmt.Fprintf(r.log, "tool %s %s\n", call.Name, args)
+	}
+	if r.observe != nil {
+		r.observe(call)
+	}
+
+	if r.confirm || t.Confirm {
+		ok, err := r.ask(fmt.Sprintf("Run tool %s with %s?", call.Name, args))
+		if err != nil {
+			result.Error = fmt.Sprintf("could not ask for confirmation: %v", err)
+			return result
+		}
+		if !ok {
+			entry.Declined = true
+			result.Error = "the user declined to run the tool"
+			return result
+		}
+	}
+
+	start := time.Now()
+	result.Output, err = r.exec(ctx, t, args)
+	entry.DurationMS = time.Since(start).Milliseconds()
+	entry.ExitCode = exitCode(err)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// askTerminal asks a yes/no question on the terminal, which works even
+// when stdin is piped.
+func askTerminal(question string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// runTools answers the model's tool calls until it replies without any,
+// sending each round with send. It returns the last request and the
+// final response, with usage summed over all rounds.
+func (r *toolRunner) runTools(ctx context.Context, req *Request, resp *Response, send func(*Request) (*Response, error)) (*Request, *Response, error) {
+	usage := resp.Usage
+	for round := 0; len(resp.ToolCalls) > 0; round++ {
+		if round == r.maxRounds {
+			return nil, nil, fmt.Errorf("model still calling tools after %d rounds", r.maxRounds)
+		}
+		next := *req
+		next.History = append(append([]Message(nil), req.History...), req.turn(), resp.turn())
+		next.Parts, next.Results = nil, nil
+		for _, call := range resp.ToolCalls {
+			next.Results = append(next.Results, r.run(ctx, call))
+		}
+
+		var err error
+		if resp, err = send(&next); err != nil {
+			return nil, nil, err
+		}
+		req = &next
+		usage = usage.Add(resp.Usage)
+	}
+
+	final := *resp
+	final.Usage = usage
+	return req, &final, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"gopkg.in/yaml.v3"
+)
+
+const toolsConfig = `
+allowed_tools: [grep_repo, echo]
+prompts:
+  - name: investigate
+    prompt: Find out why the build fails
+    tools:
+      - name: grep_repo
+        description: Search the repository
+        command: grep -rn "$(jq -r .pattern)" .
+        parameters:
+          type: object
+          properties:
+            pattern:
+              type: string
+              description: Regular expression
+          required: [pattern]
+      - name: echo
+        command: cat
+        confirm: true
+      - name: rm_rf
+        command: rm -rf /
+`
+
+func TestToolConfig(t *testing.T) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(toolsConfig), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	ctx := context.Background()
+	tools, err := newToolRunner(ctx, &cfg, cfg.ResolvePrompt("investigate"), false)
+	if err != nil || tools == nil {
+		t.Fatalf("Expected a tool runner, got %v", err)
+	}
+	var names []string
+	for _, s := range tools.specs {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"grep_repo", "echo"}) {
+		t.Errorf("Expected only allowed tools, got %q", names)
+	}
+	p := tools.specs[0].Parameters
+	if p.Type != "object" || p.Properties["pattern"].Description != "Regular expression" || p.Required[0] != "pattern" {
+		t.Errorf("Unexpected parameters: %+v", p)
+	}
+	if tools.maxRounds != defaultMaxToolRounds {
+		t.Errorf("Expected default max rounds, got %d", tools.maxRounds)
+	}
+
+	for _, allowed := range [][]string{{}, nil} {
+		cfg.AllowedTools = allowed
+		if tools, _ := newToolRunner(ctx, &cfg, cfg.ResolvePrompt("investigate"), false); tools != nil {
+			t.Errorf("Expected no runner when allowed_tools is %#v", allowed)
+		}
+	}
+}
+
+func TestDeclaredTools(t *testing.T) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(toolsConfig), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	// The server's command does not exist, so starting it would fail.
+	cfg.MCPServers = map[string]MCPServer{"fs": {Command: "/nonexistent/mcp-server"}}
+	prompt := cfg.ResolvePrompt("investigate")
+	prompt.MCP = []string{"fs"}
+
+	var names []string
+	for _, s := range declaredTools(&cfg, prompt) {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"grep_repo", "echo"}) {
+		t.Errorf("Expected the allowed declared tools, got %q", names)
+	}
+}
+
+func TestToolValidate(t *testing.T) {
+	cfg := &Config{Prompts: []Prompt{{
+		Name:   "agent",
+		Prompt: "Do things",
+		Tools: []Tool{
+			{ToolSpec: ToolSpec{Name: "bad name"}, Command: "true"},
+			{ToolSpec: ToolSpec{Name: "nocommand"}},
+			{ToolSpec: ToolSpec{Name: "scalar", Parameters: &Schema{Type: "string"}}, Command: "true"},
+			{ToolSpec: ToolSpec{Name: "typo", Parameters: &Schema{Type: "object", Properties: map[string]*Schema{"n": {Type: "int"}}}}, Command: "true"},
+			{ToolSpec: ToolSpec{Name: "dup"}, Command: "true"},
+			{ToolSpec: ToolSpec{Name: "dup"}, Command: "true"},
+		},
+	}}}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors, got nil")
+	}
+	for _, want := range []string{
+		`invalid tool name "bad name"`,
+		`tool "nocommand" has no command`,
+		`tool "scalar": parameters must be of type object`,
+		`tool typo.parameters.n: unknown type "int"`,
+		`duplicate tool "dup"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestToolRunnerRun(t *testing.T) {
+	var asked []string
+	answer := true
+	r := &toolRunner{
+		tools: map[string]Tool{
+			"echo":   {ToolSpec: ToolSpec{Name: "echo"}, Command: "cat"},
+			"fail":   {ToolSpec: ToolSpec{Name: "fail"}, Command: "echo oops >&2; exit 3"},
+			"guard":  {ToolSpec: ToolSpec{Name: "guard"}, Command: "echo ran", Confirm: true},
+			"noargs": {ToolSpec: ToolSpec{Name: "noargs"}, Command: "cat"},
+		},
+		exec: (&Sandbox{}).Run,
+		ask: func(question string) (bool, error) {
+			asked = append(asked, question)
+			return answer, nil
+		},
+	}
+	ctx := context.Background()
+
+	res := r.run(ctx, ToolCall{Name: "echo", Args: map[string]any{"pattern": "TODO"}})
+	if res.Output != `{"pattern":"TODO"}` || res.Error != "" {
+		t.Errorf("Expected arguments echoed from stdin, got %+v", res)
+	}
+	if res := r.run(ctx, ToolCall{Name: "noargs"}); res.Output != "{}" {
+		t.Errorf("Expected empty object without arguments, got %+v", res)
+	}
+	if res := r.run(ctx, ToolCall{Name: "fail"}); !strings.Contains(res.Error, "exit status 3: oops") {
+		t.Errorf("Expected exit status and stderr, got %+v", res)
+	}
+	if res := r.run(ctx, ToolCall{Name: "missing"}); res.Error != `unknown tool "missing"` {
+		t.Errorf("Expected unknown tool error, got %+v", res)
+	}
+	if len(asked) != 0 {
+		t.Errorf("Expected no confirmation for unguarded tools, got %q", asked)
+	}
+
+	if res := r.run(ctx, ToolCall{Name: "guard"}); res.Output != "ran\n" {
+		t.Errorf("Expected confirmed tool to run, got %+v", res)
+	}
+	answer = false
+	if res := r.run(ctx, ToolCall{Name: "guard"}); res.Output != "" || !strings.Contains(res.Error, "declined") {
+		t.Errorf("Expected declined tool not to run, got %+v", res)
+	}
+	if len(asked) != 2 || asked[0] != "Run tool guard with {}?" {
+		t.Errorf("Unexpected confirmation questions: %q", asked)
+	}
+
+	// --confirm-tools asks for every tool.
+	r.confirm = true
+	if res := r.run(ctx, ToolCall{Name: "echo"}); res.Output != "" {
+		t.Errorf("Expected declined tool not to run, got %+v", res)
+	}
+}
+
+func TestRunTools(t *testing.T) {
+	r := &toolRunner{
+		tools:     map[string]Tool{"lookup": {ToolSpec: ToolSpec{Name: "lookup"}}},
+		maxRounds: 3,
+		exec: func(ctx context.Context, tool Tool, args []byte) (string, error) {
+			return "result for " + string(args), nil
+		},
+	}
+
+	var sent []*Request
+	replies := []*Response{
+		{ToolCalls: []ToolCall{{Name: "lookup", Args: map[string]any{"q": "b"}}}, Usage: Usage{InputTokens: 20, OutputTokens: 2}},
+		{Text: "The answer.", Usage: Usage{InputTokens: 30, OutputTokens: 3}},
+	}
+	send := func(req *Request) (*Response, error) {
+		sent = append(sent, req)
+		return replies[len(sent)-1], nil
+	}
+
+	req := &Request{Parts: []string{"question"}, Tools: []ToolSpec{{Name: "lookup"}}}
+	first := &Response{ToolCalls: []ToolCall{{Name: "lookup", Args: map[string]any{"q": "a"}}}, Usage: Usage{InputTokens: 10, OutputTokens: 1}}
+	last, resp, err := r.runTools(context.Background(), req, first, send)
+	if err != nil {
+		t.Fatalf("runTools failed: %v", err)
+	}
+	if resp.Text != "The answer." || resp.Usage.InputTokens != 60 || resp.Usage.OutputTokens != 6 {
+		t.Errorf("Unexpected final response: %+v", resp)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 follow-up requests, got %d", len(sent))
+	}
+
+	expected := []Message{
+		{Role: "user", Parts: []string{"question"}},
+		{Role: "model", Calls: []ToolCall{{Name: "lookup", Args: map[string]any{"q": "a"}}}},
+		{Role: "user", Results: []ToolResult{{Name: "lookup", Output: `result for {"q":"a"}`}}},
+		{Role: "model", Calls: []ToolCall{{Name: "lookup", Args: map[string]any{"q": "b"}}}},
+	}
+	if !reflect.DeepEqual(last.History, expected) {
+		t.Errorf("Unexpected history:\n%+v", last.History)
+	}
+	if len(last.Parts) != 0 || len(last.Results) != 1 || last.Results[0].Output != `result for {"q":"b"}` || len(last.Tools) != 1 {
+		t.Errorf("Unexpected last request: %+v", last)
+	}
+	if len(req.History) != 0 {
+		t.Errorf("Expected the original request to be unchanged, got %+v", req.History)
+	}
+
+	// A model that never stops calling tools is cut off.
+	loop := func(req *Request) (*Response, error) { return first, nil }
+	if _, _, err := r.runTools(context.Background(), req, first, loop); err == nil || !strings.Contains(err.Error(), "after 3 rounds") {
+		t.Errorf("Expected max rounds error, got %v", err)
+	}
+
+	failed := errors.New("quota")
+	if _, _, err := r.runTools(context.Background(), req, first, func(*Request) (*Response, error) { return nil, failed }); !errors.Is(err, failed) {
+		t.Errorf("Expected send error, got %v", err)
+	}
+}
+
+func TestToolRequests(t *testing.T) {
+	req := &Request{
+		History: []Message{
+			{Role: "user", Parts: []string{"question"}},
+			{Role: "model", Calls: []ToolCall{{Name: "lookup", Args: map[string]any{"q": "a"}}}},
+		},
+		Results: []ToolResult{{Name: "lookup", Output: "42", Error: "partial"}},
+		Tools: []ToolSpec{{Name: "lookup", Description: "Look things up", Parameters: &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{"q": {Type: "string"}},
+		}}},
+	}
+
+	rest, _ := json.Marshal(newRestRequest(req))
+	for _, want := range []string{
+		`{"role":"model","parts":[{"functionCall":{"name":"lookup","args":{"q":"a"}}}]}`,
+		`{"role":"user","parts":[{"functionResponse":{"name":"lookup","response":{"error":"partial","output":"42"}}}]}`,
+		`"tools":[{"functionDeclarations":[{"name":"lookup","description":"Look things up","parameters":{"type":"OBJECT","properties":{"q":{"type":"STRING"}}}}]}]`,
+	} {
+		if !strings.Contains(string(rest), want) {
+			t.Errorf("Expected %s in REST request, got %s", want, rest)
+		}
+	}
+
+	ollama, _ := json.Marshal(newOllamaRequest("llama3", req))
+	for _, want := range []string{
+		`{"role":"assistant","content":"","tool_calls":[{"function":{"name":"lookup","arguments":{"q":"a"}}}]}`,
+		`{"role":"tool","content":"{\"error\":\"partial\",\"output\":\"42\"}","tool_name":"lookup"}]`,
+		`"tools":[{"type":"function","function":{"name":"lookup","description":"Look things up","parameters":{"type":"object","properties":{"q":{"type":"string"}}}}}]`,
+	} {
+		if !strings.Contains(string(ollama), want) {
+			t.Errorf("Expected %s in Ollama request, got %s", want, ollama)
+		}
+	}
+
+	tools := genaiTools(req.Tools)
+	decl := tools[0].FunctionDeclarations[0]
+	if decl.Name != "lookup" || decl.Parameters.Type != genai.TypeObject || decl.Parameters.Properties["q"].Type != genai.TypeString {
+		t.Errorf("Unexpected function declaration: %+v", decl)
+	}
+}
+
+func TestToolCallResponses(t *testing.T) {
+	tools := []ToolSpec{{Name: "ls"}}
+	expected := []ToolCall{{Name: "ls", Args: map[string]any{"dir": "src"}}}
+
+	client := geminiTestClient(t, `{"candidates": [{"content": {"parts": [{"functionCall": {"name": "ls", "args": {"dir": "src"}}}], "role": "model"}, "finishReason": "STOP"}]}`)
+	resp, err := client.Generate(context.Background(), &Request{Parts: []string{"list"}, Tools: tools})
+	if err != nil {
+		t.Fatalf("Gemini Generate failed: %v", err)
+	}
+	if !reflect.DeepEqual(resp.ToolCalls, expected) || len(resp.OtherParts) != 0 {
+		t.Errorf("Gemini: expected tool calls %+v, got %+v", expected, resp)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model": "llama3", "message": {"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "ls", "arguments": {"dir": "src"}}}]}, "done_reason": "stop"}`))
+	}))
+	defer server.Close()
+	resp, err = NewOllamaClient(server.URL, "llama3").Generate(context.Background(), &Request{Parts: []string{"list"}, Tools: tools})
+	if err != nil {
+		t.Fatalf("Ollama Generate failed: %v", err)
+	}
+	if !reflect.DeepEqual(resp.ToolCalls, expected) {
+		t.Errorf("Ollama: expected tool calls %+v, got %+v", expected, resp.ToolCalls)
+	}
+}
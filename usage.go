@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Price is the cost in dollars per million tokens. Cached input tokens
+// cost Input unless Cached is set.
+type Price struct {
+	Input  float64  `yaml:"input"`
+	Output float64  `yaml:"output"`
+	Cached *float64 `yaml:"cached"`
+}
+
+// UsageEntry is one line of the usage ledger.
+type UsageEntry struct {
+	Time         time.Time `json:"time"`
+	Prompt       string    `json:"prompt"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CachedTokens int       `json:"cached_tokens,omitempty"`
+}
+
+// ledgerPath returns $XDG_STATE_HOME/pipellm/usage.jsonl.
+func ledgerPath() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "usage.jsonl"), nil
+}
+
+// AppendUsage adds an entry to the ledger at path.
+func AppendUsage(path string, e UsageEntry) error {
+	return appendJSONLine(path, e)
+}
+
+// appendJSONLine appends v as one line of JSON to the file at path,
+// which only the owner may read.
+func appendJSONLine(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	// One write per line keeps concurrent pipelines from interleaving.
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordUsage logs a successful call to the ledger. Failing to record
+// usage only warns, since the response has already been paid for.
+func recordUsage(prompt, provider, model string, resp *Response) {
+	path, err := ledgerPath()
+	if err == nil {
+		err = AppendUsage(path, UsageEntry{
+			Time:         time.Now().UTC(),
+			Prompt:       prompt,
+			Provider:     provider,
+			Model:        model,
+			InputTokens:  resp.Usage.InputTokens,
+			OutputTokens: resp.Usage.OutputTokens,
+			CachedTokens: resp.Usage.CachedTokens,
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: recording usage: %v\n", err)
+	}
+}
+
+// ReadUsage returns the ledger entries at or after since. Malformed lines
+// are skipped.
+func ReadUsage(path string, since time.Time) ([]UsageEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []UsageEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e UsageEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// findPrice returns the price for model. Keys may be glob patterns such
+// as "gemini-2.5-flash*"; an exact match wins.
+func findPrice(prices map[string]Price, model string) (Price, bool) {
+	if p, ok := prices[model]; ok {
+		return p, true
+	}
+	keys := make([]string, 0, len(prices))
+	for k := range prices {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if ok, _ := path.Match(k, model); ok {
+			return prices[k], true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost returns the dollar cost of e, and false if its model has no price.
+func (e UsageEntry) Cost(prices map[string]Price) (float64, bool) {
+	p, ok := findPrice(prices, e.Model)
+	if !ok {
+		return 0, false
+	}
+	cached := p.Input
+	if p.Cached != nil {
+		cached = *p.Cached
+	}
+	// Cached tokens are included in the input token count.
+	cost := float64(e.InputTokens-e.CachedTokens)*p.Input +
+		float64(e.CachedTokens)*cached +
+		float64(e.OutputTokens)*p.Output
+	return cost / 1e6, true
+}
+
+// parseSince parses "7d", "12h", "30m" or a YYYY-MM-DD date.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want e.g. 7d, 12h or 2006-01-02)", s)
+}
+
+type usageRow struct {
+	key                   string
+	calls                 int
+	input, output, cached int
+	cost                  float64
+	priced                bool
+}
+
+// summarizeUsage groups entries by prompt or model, most expensive first.
+func summarizeUsage(entries []UsageEntry, by string, prices map[string]Price) []usageRow {
+	rows := make(map[string]*usageRow)
+	for _, e := range entries {
+		key := e.Model
+		if by == "prompt" {
+			key = e.Prompt
+		}
+		r, ok := rows[key]
+		if !ok {
+			r = &usageRow{key: key, priced: true}
+			rows[key] = r
+		}
+		r.calls++
+		r.input += e.InputTokens
+		r.output += e.OutputTokens
+		r.cached += e.CachedTokens
+		cost, ok := e.Cost(prices)
+		r.cost += cost
+		r.priced = r.priced && ok
+	}
+
+	out := make([]usageRow, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].cost != out[j].cost {
+			return out[i].cost > out[j].cost
+		}
+		if out[i].input+out[i].output != out[j].input+out[j].output {
+			return out[i].input+out[i].output > out[j].input+out[j].output
+		}
+		return out[i].key < out[j].key
+	})
+	return out
+}
+
+func writeUsageReport(out io.Writer, by string, rows []usageRow) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tCALLS\tINPUT\tOUTPUT\tCACHED\tCOST\n", strings.ToUpper(by))
+
+	total := usageRow{key: "total", priced: true}
+	for _, r := range rows {
+		writeUsageRow(w, r)
+		total.calls += r.calls
+		total.input += r.input
+		total.output += r.output
+		total.cached += r.cached
+		total.cost += r.cost
+		total.priced = total.priced && r.priced
+	}
+	writeUsageRow(w, total)
+	w.Flush()
+	if !total.priced {
+		fmt.Fprintln(out, "* includes models without a price in the config")
+	}
+}
+
+func writeUsageRow(w io.Writer, r usageRow) {
+	cost := fmt.Sprintf("$%.4f", r.cost)
+	if !r.priced {
+		// Some calls used models missing from the price table.
+		cost += "*"
+	}
+	key := r.key
+	if key == "" {
+		key = "-"
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", key, r.calls, r.input, r.output, r.cached, cost)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "usage.jsonl")
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	entries := []UsageEntry{
+		{Time: now.AddDate(0, 0, -10), Prompt: "review", Model: "gemini-2.5-pro", InputTokens: 999, OutputTokens: 999},
+		{Time: now.Add(-time.Hour), Prompt: "review", Model: "gemini-2.5-pro", InputTokens: 1000, OutputTokens: 200},
+		{Time: now.Add(-time.Minute), Prompt: "summary", Model: "gemini-2.5-flash", InputTokens: 500, OutputTokens: 100, CachedTokens: 100},
+	}
+	for _, e := range entries {
+		if err := AppendUsage(path, e); err != nil {
+			t.Fatalf("AppendUsage failed: %v", err)
+		}
+	}
+	// A truncated line from a crashed writer is skipped.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"time": "2026-05`)
+	f.Close()
+
+	got, err := ReadUsage(path, now.AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatalf("ReadUsage failed: %v", err)
+	}
+	if len(got) != 2 || got[0].Prompt != "review" || got[1].CachedTokens != 100 {
+		t.Errorf("Unexpected entries: %+v", got)
+	}
+
+	missing, err := ReadUsage(filepath.Join(t.TempDir(), "none.jsonl"), now)
+	if err != nil || len(missing) != 0 {
+		t.Errorf("Expected no entries and no error for a missing ledger, got %v, %v", missing, err)
+	}
+}
+
+func TestUsageEntryCost(t *testing.T) {
+	cachedPrice := 0.5
+	prices := map[string]Price{
+		"gemini-2.5-pro": {Input: 1.25, Output: 10},
+		"gemini-2.5-*":   {Input: 0.3, Output: 2.5, Cached: &cachedPrice},
+	}
+
+	tests := []struct {
+		entry    UsageEntry
+		expected float64
+		priced   bool
+	}{
+		{UsageEntry{Model: "gemini-2.5-pro", InputTokens: 1_000_000, OutputTokens: 100_000}, 2.25, true},
+		{UsageEntry{Model: "gemini-2.5-flash", InputTokens: 1_000_000, CachedTokens: 200_000}, 0.34, true},
+		{UsageEntry{Model: "llama3", InputTokens: 1_000_000}, 0, false},
+	}
+
+	for _, tt := range tests {
+		cost, priced := tt.entry.Cost(prices)
+		if priced != tt.priced || cost < tt.expected-1e-9 || cost > tt.expected+1e-9 {
+			t.Errorf("%s: expected %v (priced %v), got %v (priced %v)", tt.entry.Model, tt.expected, tt.priced, cost, priced)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"7d", time.Date(2026, 5, 3, 12, 0, 0, 0, time.UTC)},
+		{"12h", time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)},
+		{"2026-05-01", time.Date(2026, 5, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.input, now)
+		if err != nil {
+			t.Errorf("parseSince(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.expected) {
+			t.Errorf("parseSince(%q): expected %v, got %v", tt.input, tt.expected, got)
+		}
+	}
+
+	for _, input := range []string{"", "week", "-3d"} {
+		if _, err := parseSince(input, now); err == nil {
+			t.Errorf("Expected error for %q, got nil", input)
+		}
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	prices := map[string]Price{"gemini-2.5-pro": {Input: 1.25, Output: 10}}
+	entries := []UsageEntry{
+		{Prompt: "summary", Model: "llama3", InputTokens: 5000, OutputTokens: 500},
+		{Prompt: "review", Model: "gemini-2.5-pro", InputTokens: 1000, OutputTokens: 200},
+		{Prompt: "review", Model: "gemini-2.5-pro", InputTokens: 3000, OutputTokens: 800},
+	}
+
+	var out bytes.Buffer
+	writeUsageReport(&out, "prompt", summarizeUsage(entries, "prompt", prices))
+
+	expected := strings.Join([]string{
+		"PROMPT   CALLS  INPUT  OUTPUT  CACHED  COST",
+		"review   2      4000   1000    0       $0.0150",
+		"summary  1      5000   500     0       $0.0000*",
+		"total    3      9000   1500    0       $0.0150*",
+		"* includes models without a price in the config",
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Errorf("Unexpected report:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Variable is a {{name}} placeholder in a prompt's text and system
+// instruction, filled in on every run.
+type Variable struct {
+	Name        string  `yaml:"name"`
+	Description string  `yaml:"description"`
+	Default     *string `yaml:"default"` // without one the variable is required
+}
+
+var (
+	variableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderRe  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// varFlag is the repeatable --var name=value flag.
+type varFlag map[string]string
+
+func (f *varFlag) String() string {
+	var pairs []string
+	for name, value := range *f {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *varFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || !variableNameRe.MatchString(name) {
+		return fmt.Errorf("want name=value")
+	}
+	if *f == nil {
+		*f = make(varFlag)
+	}
+	(*f)[name] = value
+	return nil
+}
+
+func validateVariables(vars []Variable) error {
+	seen := make(map[string]bool)
+	for _, v := range vars {
+		if !variableNameRe.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if v.Name == "input" {
+			// The prompt's input has this name in mcp serve.
+			return fmt.Errorf("variable name %q is reserved", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("duplicate variable %q", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return nil
+}
+
+// Expand returns a copy of p with its variables replaced by values or
+// their defaults. Placeholders that are not declared stay as they are.
+func (p *Prompt) Expand(values map[string]string) (*Prompt, error) {
+	set := make(map[string]string)
+	var missing []string
+	for _, v := range p.Variables {
+		if value, ok := values[v.Name]; ok {
+			set[v.Name] = value
+		} else if v.Default != nil {
+			set[v.Name] = *v.Default
+		} else {
+			missing = append(missing, v.Name)
+		}
+	}
+	var unknown []string
+	for name := range values {
+		if _, ok := set[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("prompt %s has no variable %s", p.Name, strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("prompt %s needs variable %s", p.Name, strings.Join(missing, ", "))
+	}
+
+	replace := func(text string) string {
+		return placeholderRe.ReplaceAllStringFunc(text, func(m string) string {
+			if value, ok := set[placeholderRe.FindStringSubmatch(m)[1]]; ok {
+				return value
+			}
+			return m
+		})
+	}
+	out := *p
+	out.Prompt = replace(p.Prompt)
+	out.System = replace(p.System)
+	return &out, nil
+}
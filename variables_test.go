@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const variablesConfig = `
+prompts:
+  - name: translate
+    system: Translate into {{ language }}.
+    prompt: Translate this {{kind}}. Keep {{.Name}} and {{other}} as they are.
+    variables:
+      - name: language
+        description: Target language
+      - name: kind
+        default: text
+`
+
+func TestExpand(t *testing.T) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(variablesConfig), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	prompt := cfg.ResolvePrompt("translate")
+
+	p, err := prompt.Expand(map[string]string{"language": "German"})
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	if p.System != "Translate into German." {
+		t.Errorf("Unexpected system instruction %q", p.System)
+	}
+	if p.Prompt != "Translate this text. Keep {{.Name}} and {{other}} as they are." {
+		t.Errorf("Unexpected prompt %q", p.Prompt)
+	}
+	if !strings.Contains(prompt.Prompt, "{{kind}}") {
+		t.Error("Expected Expand to leave the original prompt alone")
+	}
+
+	p, _ = prompt.Expand(map[string]string{"language": "French", "kind": "poem"})
+	if p.Prompt != "Translate this poem. Keep {{.Name}} and {{other}} as they are." {
+		t.Errorf("Unexpected prompt %q", p.Prompt)
+	}
+
+	if _, err := prompt.Expand(nil); err == nil || !strings.Contains(err.Error(), "needs variable language") {
+		t.Errorf("Expected error for a missing variable, got %v", err)
+	}
+	if _, err := prompt.Expand(map[string]string{"language": "German", "tone": "formal"}); err == nil || !strings.Contains(err.Error(), "no variable tone") {
+		t.Errorf("Expected error for an unknown variable, got %v", err)
+	}
+}
+
+func TestVarFlag(t *testing.T) {
+	var opts runOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.register(fs, "")
+	if err := fs.Parse([]string{"--var", "language=German", "--var=note=a=b"}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if opts.vars["language"] != "German" || opts.vars["note"] != "a=b" {
+		t.Errorf("Unexpected vars %v", opts.vars)
+	}
+
+	for _, arg := range []string{"language", "=x", "two words=x"} {
+		var f varFlag
+		if err := f.Set(arg); err == nil {
+			t.Errorf("Expected error for %q", arg)
+		}
+	}
+}
+
+func TestValidateVariables(t *testing.T) {
+	tests := []struct {
+		vars     []Variable
+		expected string
+	}{
+		{[]Variable{{Name: "a"}, {Name: "b"}}, ""},
+		{[]Variable{{Name: "a-b"}}, "invalid variable name"},
+		{[]Variable{{Name: "a"}, {Name: "a"}}, "duplicate variable"},
+		{[]Variable{{Name: "input"}}, "reserved"},
+	}
+	for _, tt := range tests {
+		err := validateVariables(tt.vars)
+		if tt.expected == "" && err != nil {
+			t.Errorf("%+v: expected no error, got %v", tt.vars, err)
+		}
+		if tt.expected != "" && (err == nil || !strings.Contains(err.Error(), tt.expected)) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.vars, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+const defaultVertexLocation = "us-central1"
+
+// VertexClient calls Gemini models through the Vertex AI REST API,
+// authenticating with Application Default Credentials.
+type VertexClient struct {
+	httpClient *http.Client
+	url        string // of generateContent
+	streamURL  string // of streamGenerateContent, with server-sent events
+}
+
+// Vertex AI accepts the same generateContent JSON as the Gemini API.
+type restPart struct {
+	Text             string                `json:"text,omitempty"`
+	FunctionCall     *restFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *restFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type restFunctionCall struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type restFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type restTool struct {
+	FunctionDeclarations []restFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type restFunctionDeclaration struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Parameters  *Schema `json:"parameters,omitempty"`
+}
+
+type restContent struct {
+	Role  string     `json:"role,omitempty"`
+	Parts []restPart `json:"parts"`
+}
+
+type restGenerationConfig struct {
+	Temperature     *float32 `json:"temperature,omitempty"`
+	TopP            *float32 `json:"topP,omitempty"`
+	TopK            *int32   `json:"topK,omitempty"`
+	MaxOutputTokens *int32   `json:"maxOutputTokens,omitempty"`
+}
+
+type restRequest struct {
+	SystemInstruction *restContent          `json:"systemInstruction,omitempty"`
+	Contents          []restContent         `json:"contents"`
+	GenerationConfig  *restGenerationConfig `json:"generationConfig,omitempty"`
+	SafetySettings    []restSafetySetting   `json:"safetySettings,omitempty"`
+	Tools             []restTool            `json:"tools,omitempty"`
+}
+
+type restSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type restResponse struct {
+	Candidates []struct {
+		Content       *restContent   `json:"content"`
+		FinishReason  string         `json:"finishReason"`
+		SafetyRatings []SafetyRating `json:"safetyRatings"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	PromptFeedback struct {
+		BlockReason   string         `json:"blockReason"`
+		SafetyRatings []SafetyRating `json:"safetyRatings"`
+	} `json:"promptFeedback"`
+	ModelVersion string `json:"modelVersion"`
+	ResponseID   string `json:"responseId"`
+}
+
+type restError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// vertexProject returns project, or $GOOGLE_CLOUD_PROJECT without one.
+func vertexProject(project string) (string, error) {
+	if project == "" {
+		project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if project == "" {
+		return "", fmt.Errorf("vertex provider requires project (or GOOGLE_CLOUD_PROJECT)")
+	}
+	return project, nil
+}
+
+func NewVertexClient(project, location, endpoint, modelName string) (*VertexClient, error) {
+	project, err := vertexProject(project)
+	if err != nil {
+		return nil, err
+	}
+	if location == "" {
+		location = defaultVertexLocation
+	}
+	if endpoint == "" {
+		endpoint = "https://" + location + "-aiplatform.googleapis.com"
+		if location == "global" {
+			endpoint = "https://aiplatform.googleapis.com"
+		}
+	}
+
+	httpClient, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google credentials: %w", err)
+	}
+
+	base := fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/google/models/%s",
+		strings.TrimRight(endpoint, "/"), project, location, modelName)
+	return &VertexClient{
+		httpClient: httpClient,
+		url:        base + ":generateContent",
+		streamURL:  base + ":streamGenerateContent?alt=sse",
+	}, nil
+}
+
+func newRestContent(m Message) restContent {
+	content := restContent{Role: m.Role}
+	for _, p := range m.Parts {
+		content.Parts = append(content.Parts, restPart{Text: p})
+	}
+	for _, c := range m.Calls {
+		content.Parts = append(content.Parts, restPart{FunctionCall: &restFunctionCall{Name: c.Name, Args: c.Args}})
+	}
+	for _, r := range m.Results {
+		content.Parts = append(content.Parts, restPart{FunctionResponse: &restFunctionResponse{Name: r.Name, Response: r.response()}})
+	}
+	return content
+}
+
+// restSchema returns s with the upper-case type names of the REST API.
+func restSchema(s *Schema) *Schema {
+	if s == nil {
+		return nil
+	}
+	out := *s
+	out.Type = strings.ToUpper(s.Type)
+	out.Items = restSchema(s.Items)
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*Schema, len(s.Properties))
+		for name, p := range s.Properties {
+			out.Properties[name] = restSchema(p)
+		}
+	}
+	return &out
+}
+
+func newRestRequest(req *Request) restRequest {
+	var out restRequest
+	for _, m := range req.History {
+		out.Contents = append(out.Contents, newRestContent(m))
+	}
+	out.Contents = append(out.Contents, newRestContent(req.turn()))
+	if req.System != "" {
+		out.SystemInstruction = &restContent{Parts: []restPart{{Text: req.System}}}
+	}
+	if req.Params != (Params{}) {
+		out.GenerationConfig = &restGenerationConfig{
+			Temperature:     req.Params.Temperature,
+			TopP:            req.Params.TopP,
+			TopK:            req.Params.TopK,
+			MaxOutputTokens: req.Params.MaxOutputTokens,
+		}
+	}
+	out.SafetySettings = req.Safety.restSettings()
+	if len(req.Tools) > 0 {
+		var tool restTool
+		for _, t := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, restFunctionDeclaration{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  restSchema(t.Parameters),
+			})
+		}
+		out.Tools = []restTool{tool}
+	}
+	return out
+}
+
+func (c *VertexClient) Generate(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := c.post(ctx, c.url, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out restResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to parse Vertex response: %w", err)
+	}
+	return out.response()
+}
+
+// GenerateStream is Generate, passing on the text as it arrives.
+func (c *VertexClient) GenerateStream(ctx context.Context, req *Request, onText func(string)) (*Response, error) {
+	resp, err := c.post(ctx, c.streamURL, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out restResponse
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16<<20) // every chunk is one line
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var chunk restResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to parse Vertex response: %w", err)
+		}
+		for _, cand := range chunk.Candidates[:min(1, len(chunk.Candidates))] {
+			if cand.Content == nil {
+				continue
+			}
+			for _, part := range cand.Content.Parts {
+				if part.Text != "" {
+					onText(part.Text)
+				}
+			}
+		}
+		out.add(&chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out.response()
+}
+
+// post sends req to url and returns the response if its status is OK.
+func (c *VertexClient) post(ctx context.Context, url string, req *Request) (*http.Response, error) {
+	body, err := json.Marshal(newRestRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var apiErr restError
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+		return nil, &APIError{Provider: "vertex", StatusCode: resp.StatusCode,
+			Message: fmt.Sprintf("%s (%s)", apiErr.Error.Message, apiErr.Error.Status)}
+	}
+	return nil, &APIError{Provider: "vertex", StatusCode: resp.StatusCode, Message: "unexpected status " + resp.Status}
+}
+
+// add merges chunk, the next part of a streamed response, into out.
+// Text and calls add up; the other fields come with the last chunks.
+func (out *restResponse) add(chunk *restResponse) {
+	if len(out.Candidates) == 0 {
+		out.Candidates = chunk.Candidates
+	} else if len(chunk.Candidates) > 0 {
+		cand, next := &out.Candidates[0], chunk.Candidates[0]
+		switch {
+		case cand.Content == nil:
+			cand.Content = next.Content
+		case next.Content != nil:
+			cand.Content.Parts = append(cand.Content.Parts, next.Content.Parts...)
+		}
+		if next.FinishReason != "" {
+			cand.FinishReason = next.FinishReason
+		}
+		if next.SafetyRatings != nil {
+			cand.SafetyRatings = next.SafetyRatings
+		}
+	}
+	if chunk.UsageMetadata.TotalTokenCount > 0 {
+		out.UsageMetadata = chunk.UsageMetadata
+	}
+	if chunk.PromptFeedback.BlockReason != "" {
+		out.PromptFeedback = chunk.PromptFeedback
+	}
+	if chunk.ModelVersion != "" {
+		out.ModelVersion = chunk.ModelVersion
+	}
+	if chunk.ResponseID != "" {
+		out.ResponseID = chunk.ResponseID
+	}
+}
+
+// response converts out, classifying answers without text.
+func (out *restResponse) response() (*Response, error) {
+	if fb := out.PromptFeedback; fb.BlockReason != "" {
+		return nil, &ResponseError{Kind: ErrBlocked, Provider: "Vertex", BlockReason: fb.BlockReason, SafetyRatings: fb.SafetyRatings}
+	}
+	if len(out.Candidates) == 0 {
+		return nil, &ResponseError{Kind: ErrEmpty, Provider: "Vertex"}
+	}
+
+	cand := out.Candidates[0]
+	var result string
+	var calls []ToolCall
+	if cand.Content != nil {
+		for _, part := range cand.Content.Parts {
+			result += part.Text
+			if part.FunctionCall != nil {
+				calls = append(calls, ToolCall{Name: part.FunctionCall.Name, Args: part.FunctionCall.Args})
+			}
+		}
+	}
+	if (result == "" && len(calls) == 0) || blockedFinishReasons[cand.FinishReason] {
+		return nil, checkFinish("Vertex", cand.FinishReason, cand.SafetyRatings)
+	}
+
+	return &Response{
+		Text:          result,
+		Model:         out.ModelVersion,
+		FinishReason:  cand.FinishReason,
+		SafetyRatings: cand.SafetyRatings,
+		ToolCalls:     calls,
+		Usage: Usage{
+			InputTokens:  out.UsageMetadata.PromptTokenCount,
+			OutputTokens: out.UsageMetadata.CandidatesTokenCount,
+			CachedTokens: out.UsageMetadata.CachedContentTokenCount,
+			TotalTokens:  out.UsageMetadata.TotalTokenCount,
+		},
+		RequestID: out.ResponseID,
+	}, nil
+}
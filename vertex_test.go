@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVertexClientSendPrompt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expectedPath := "/v1/projects/proj/locations/europe-west4/publishers/google/models/gemini-1.5-flash:generateContent"
+		if r.URL.Path != expectedPath {
+			t.Errorf("Expected path %q, got %q", expectedPath, r.URL.Path)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("Failed to read request body: %v", err)
+		}
+
+		var req restRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("Failed to unmarshal request body: %v", err)
+		}
+
+		if len(req.Contents) != 1 || len(req.Contents[0].Parts) != 1 {
+			t.Fatalf("Expected 1 content with 1 part, got %+v", req)
+		}
+		if req.Contents[0].Parts[0].Text != "Test prompt\n\nTest input" {
+			t.Errorf("Unexpected content %q", req.Contents[0].Parts[0].Text)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Vertex "}, {"text": "response"}]}}]}`))
+	}))
+	defer server.Close()
+
+	client := &VertexClient{
+		httpClient: http.DefaultClient,
+		url:        server.URL + "/v1/projects/proj/locations/europe-west4/publishers/google/models/gemini-1.5-flash:generateContent",
+	}
+
+	resp, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, "Test input"))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if resp.Text != "Vertex response" {
+		t.Errorf("Expected response %q, got %q", "Vertex response", resp.Text)
+	}
+}
+
+func TestVertexClientSendPromptAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"code": 403, "message": "Permission denied", "status": "PERMISSION_DENIED"}}`))
+	}))
+	defer server.Close()
+
+	client := &VertexClient{httpClient: http.DefaultClient, url: server.URL}
+	_, err := client.Generate(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""))
+	if err == nil {
+		t.Fatal("Expected error for API failure, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("Expected API error message, got %v", err)
+	}
+}
+
+func TestVertexClientGenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("Unexpected URL %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "Vertex "}]}}], "modelVersion": "gemini-002"}`+"\r\n\r\n")
+		io.WriteString(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "response"}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 2, "totalTokenCount": 6}, "responseId": "abc"}`+"\r\n\r\n")
+	}))
+	defer server.Close()
+
+	client := &VertexClient{httpClient: http.DefaultClient, streamURL: server.URL + "/models/gemini:streamGenerateContent?alt=sse"}
+
+	var texts []string
+	resp, err := client.GenerateStream(context.Background(), NewRequest(&Prompt{Prompt: "Test prompt"}, ""), func(text string) {
+		texts = append(texts, text)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream failed: %v", err)
+	}
+
+	if strings.Join(texts, "|") != "Vertex |response" {
+		t.Errorf("Expected the text in two pieces, got %q", texts)
+	}
+	if resp.Text != "Vertex response" || resp.FinishReason != "STOP" || resp.Model != "gemini-002" || resp.RequestID != "abc" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	if resp.Usage != (Usage{InputTokens: 4, OutputTokens: 2, TotalTokens: 6}) {
+		t.Errorf("Unexpected usage %+v", resp.Usage)
+	}
+}